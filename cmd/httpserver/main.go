@@ -1,118 +1,160 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/mugiwara999/httpfromtcp/internal/headers"
+	"github.com/mugiwara999/httpfromtcp/internal/middleware"
 	"github.com/mugiwara999/httpfromtcp/internal/request"
 	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/mugiwara999/httpfromtcp/internal/router"
 	"github.com/mugiwara999/httpfromtcp/internal/server"
 )
 
 const port = 42069
 
-func main() {
-	handler := func(w *response.Writer, req *request.Request) *server.HandlerError {
-		body := "<html><head>    <title>200 OK</title>  </head>  <body>    <h1>Success!</h1>    <p>Your request was an absolute banger.</p>  </body></html>"
-
-		status := response.StatusOK
-
-		if req.RequestLine.RequestTarget == "/yourproblem" {
-			status = response.StatusBadRequest
-			body = "<html><head><title>400 Bad Request</title></head><body><h1>Bad Request</h1><p>Your request honestly kinda sucked.</p></body></html>"
-
-		} else if req.RequestLine.RequestTarget == "/myproblem" {
-			status = response.StatusInternalServerError
-			body = "<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>Okay, you know what? This one is on me.</p></body></html>"
-
-		} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-
-			target := req.RequestLine.RequestTarget
-
-			res, err := http.Get("https://httpbin.org/" + target[len("/httpbin/"):])
-
-			if err != nil {
-
-				status = response.StatusInternalServerError
-				body = "<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>Okay, you know what? This one is on me.</p></body></html>"
-				log.Println(err)
-			} else {
-
-				w.WriteStatusLine(response.StatusOK)
-				h := response.GetDefaultHeader(len(body))
-				h.Delete("content-length")
-				h.Set("Transfer-encoding", "chunked")
-				h.Set("content-type", "text/plain")
-				h.Set("Trialer", "X-content-sha256")
-				h.Set("Trialer", "X-Content-Length")
-				w.WriteHeaders(h)
-
-				fullBody := []byte{}
-
-				for {
-					data := make([]byte, 32)
-					n, err := res.Body.Read(data)
-					defer res.Body.Close()
-					if err != nil {
-						break
-					}
-
-					fullBody = append(fullBody, data[:n]...)
-					w.Write(fmt.Appendf(nil, "%x\r\n", n))
-					w.Write(data[:n])
-					w.Write([]byte("\r\n"))
-				}
-				w.Write([]byte("0\r\n"))
-
-				hashval := sha256.Sum256(fullBody)
-
-				trailer := headers.NewHeaders()
-				trailer.Set("X-content-sha256", fmt.Sprintf("%x", hashval))
-				trailer.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-				w.WriteTrailer(trailer)
-				return nil
-			}
-
-		} else if req.RequestLine.RequestTarget == "/video" {
-			f, err := os.ReadFile("./assets/vim.mp4")
-			if err != nil {
-				return nil
-			}
-
-			h := response.GetDefaultHeader(0)
-			h.Delete("content-length")
-			h.Replace("content-type", "video/mp4")
-			w.WriteStatusLine(200)
-			w.WriteHeaders(h)
-			w.Write(f)
-			return nil
+const shutdownTimeout = 10 * time.Second
+
+const (
+	okBody            = "<html><head>    <title>200 OK</title>  </head>  <body>    <h1>Success!</h1>    <p>Your request was an absolute banger.</p>  </body></html>"
+	badRequestBody    = "<html><head><title>400 Bad Request</title></head><body><h1>Bad Request</h1><p>Your request honestly kinda sucked.</p></body></html>"
+	internalErrorBody = "<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>Okay, you know what? This one is on me.</p></body></html>"
+)
+
+func writeHTML(w *response.Writer, status response.StatusCode, body string) {
+	w.WriteStatusLine(status)
+	h := headers.NewHeaders()
+	h.Set("content-type", "text/html")
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
+
+func handleRoot(w *response.Writer, req *request.Request) *server.HandlerError {
+	writeHTML(w, response.StatusOK, okBody)
+	return nil
+}
 
+func handleYourProblem(w *response.Writer, req *request.Request) *server.HandlerError {
+	writeHTML(w, response.StatusBadRequest, badRequestBody)
+	return nil
+}
+
+func handleMyProblem(w *response.Writer, req *request.Request) *server.HandlerError {
+	writeHTML(w, response.StatusInternalServerError, internalErrorBody)
+	return nil
+}
+
+func handleHTTPBin(w *response.Writer, req *request.Request) *server.HandlerError {
+	// Forward the path and query as sent, so that escaping survives the trip.
+	upstream := "https://httpbin.org/" + strings.TrimPrefix(req.Target.RawPath, "/httpbin/")
+	if req.Target.RawQuery != "" {
+		upstream += "?" + req.Target.RawQuery
+	}
+	res, err := http.Get(upstream)
+	if err != nil {
+		log.Println(err)
+		writeHTML(w, response.StatusInternalServerError, internalErrorBody)
+		return nil
+	}
+	defer res.Body.Close()
+
+	w.WriteStatusLine(response.StatusOK)
+	h := response.GetDefaultHeader(0)
+	h.Delete("content-length")
+	h.Set("trailer", "X-Content-SHA256, X-Content-Length")
+	w.WriteHeaders(h)
+
+	fullBody := []byte{}
+	data := make([]byte, 32)
+
+	for {
+		n, err := res.Body.Read(data)
+		if n > 0 {
+			fullBody = append(fullBody, data[:n]...)
+			w.WriteChunkedBody(data[:n])
+			w.Flush()
 		}
+		if err != nil {
+			break
+		}
+	}
+	w.WriteChunkedBodyDone()
 
-		w.WriteStatusLine(status)
-		h := response.GetDefaultHeader(len(body))
-		h.Set("content-type", "text/html")
-		w.WriteHeaders(h)
-		w.WriteBody([]byte(body))
+	hashval := sha256.Sum256(fullBody)
+
+	trailer := headers.NewHeaders()
+	trailer.Set("X-Content-SHA256", fmt.Sprintf("%x", hashval))
+	trailer.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
+	w.WriteTrailer(trailer)
+	return nil
+}
 
+func handleVideo(w *response.Writer, req *request.Request) *server.HandlerError {
+	f, err := os.Open("./assets/vim.mp4")
+	if err != nil {
 		return nil
 	}
-	server, err := server.Serve(port, handler)
+	defer f.Close()
+
+	info, err := f.Stat()
 	if err != nil {
+		return nil
+	}
+
+	h := response.GetDefaultHeader(int(info.Size()))
+	h.Replace("content-type", "video/mp4")
+	w.WriteStatusLine(200)
+	w.WriteHeaders(h)
+	io.Copy(w, f)
+	return nil
+}
+
+func main() {
+	r := router.New()
+	r.Handle("GET", "/", handleRoot)
+	r.Handle("GET", "/yourproblem", handleYourProblem)
+	r.Handle("GET", "/myproblem", handleMyProblem)
+	r.Handle("GET", "/httpbin/*", handleHTTPBin)
+	r.Handle("GET", "/video", handleVideo)
+
+	logger := log.Default()
+	handler := middleware.Chain(
+		middleware.Logger(logger),
+		middleware.Recover(logger),
+		middleware.RequestID(),
+	)(r.Serve)
+
+	server := &server.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+		ServerHeader:      "httpfromtcp",
+	}
+	if err := server.Start(port); err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	log.Println("Server started on port", port)
-	defer server.Close()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net"
 
@@ -43,8 +44,9 @@ func main() {
 
 			_, ok := r.Headers.Get("Content-Length")
 			if ok {
+				body, _ := io.ReadAll(r.Body)
 				fmt.Println("Body:")
-				fmt.Println(string(r.Body))
+				fmt.Println(string(body))
 			}
 
 			c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK"))
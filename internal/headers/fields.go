@@ -0,0 +1,214 @@
+package headers
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is IMF-fixdate, the preferred format of HTTP dates (RFC 9110
+// section 5.6.7).
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Obsolete date formats that recipients must still accept.
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+var (
+	ErrorNoField      = fmt.Errorf("header field not present")
+	ErrorInvalidValue = fmt.Errorf("invalid header field value")
+)
+
+// SplitList splits a comma-separated list value into its trimmed, non-empty
+// elements. Commas inside quoted strings do not separate elements.
+func SplitList(value string) []string {
+	elements := []string{}
+	for _, e := range splitQuoted(value, ',') {
+		if e = strings.Trim(e, " \t"); e != "" {
+			elements = append(elements, e)
+		}
+	}
+	return elements
+}
+
+// Elements returns the elements of the list-valued field called name,
+// across all of its lines.
+func (h *Headers) Elements(name string) []string {
+	values, _ := h.Get(name)
+
+	elements := []string{}
+	for _, v := range values {
+		elements = append(elements, SplitList(v)...)
+	}
+	return elements
+}
+
+// Params holds the parameters of a field value such as
+// "text/html; charset=utf-8", by lowercase name. Quoted values are unquoted.
+type Params map[string]string
+
+// SplitParams splits value into the part before its first semicolon and the
+// parameters after it. Parameters without a name or value are skipped.
+func SplitParams(value string) (string, Params) {
+	params := Params{}
+	parts := splitQuoted(value, ';')
+	for _, p := range parts[1:] {
+		name, v, ok := strings.Cut(p, "=")
+		name = strings.ToLower(strings.Trim(name, " \t"))
+		v = strings.Trim(v, " \t")
+		if !ok || name == "" || v == "" {
+			continue
+		}
+		if _, seen := params[name]; !seen {
+			params[name] = unquote(v)
+		}
+	}
+	return strings.Trim(parts[0], " \t"), params
+}
+
+// Params splits the value of the field called name, like SplitParams. ok is
+// false if the field is not present.
+func (h *Headers) Params(name string) (value string, params Params, ok bool) {
+	values, ok := h.Get(name)
+	if !ok || len(values) == 0 {
+		return "", nil, false
+	}
+	value, params = SplitParams(values[0])
+	return value, params, true
+}
+
+// Weighted is an element of a list such as Accept or Accept-Encoding,
+// with its quality value.
+type Weighted struct {
+	Value  string
+	Params Params
+	Q      float64
+}
+
+// Weighted returns the elements of the list-valued field called name,
+// ordered by descending quality value and, for equal ones, as sent. The "q"
+// parameter is removed from Params; it defaults to 1. Elements whose quality
+// value is malformed are dropped.
+func (h *Headers) Weighted(name string) []Weighted {
+	weighted := []Weighted{}
+	for _, e := range h.Elements(name) {
+		value, params := SplitParams(e)
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			var valid bool
+			if q, valid = parseQValue(v); !valid {
+				continue
+			}
+			delete(params, "q")
+		}
+		weighted = append(weighted, Weighted{Value: value, Params: params, Q: q})
+	}
+
+	slices.SortStableFunc(weighted, func(a, b Weighted) int {
+		return cmp.Compare(b.Q, a.Q)
+	})
+	return weighted
+}
+
+// parseQValue parses qvalue = ( "0" [ "." 0*3DIGIT ] ) / ( "1" [ "." 0*3("0") ] ).
+func parseQValue(v string) (float64, bool) {
+	if v == "" || (v[0] != '0' && v[0] != '1') {
+		return 0, false
+	}
+	if len(v) > 1 {
+		frac := v[2:]
+		if v[1] != '.' || len(frac) > 3 || strings.Trim(frac, "0123456789") != "" {
+			return 0, false
+		}
+		if v[0] == '1' && strings.Trim(frac, "0") != "" {
+			return 0, false
+		}
+	}
+	q, err := strconv.ParseFloat(v, 64)
+	return q, err == nil
+}
+
+// Int returns the value of the field called name as a non-negative decimal
+// integer, such as Content-Length, Age or Max-Forwards. A field repeated
+// with the same value is accepted.
+func (h *Headers) Int(name string) (int64, error) {
+	elements := h.Elements(name)
+	if len(elements) == 0 {
+		if _, ok := h.Get(name); ok {
+			return 0, ErrorInvalidValue
+		}
+		return 0, ErrorNoField
+	}
+
+	for _, e := range elements {
+		if e != elements[0] {
+			return 0, ErrorInvalidValue
+		}
+	}
+	v := elements[0]
+	if len(v) > 18 || strings.Trim(v, "0123456789") != "" {
+		return 0, ErrorInvalidValue
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// Time returns the value of the field called name as an HTTP date. Besides
+// IMF-fixdate, it accepts the obsolete RFC 850 and asctime formats.
+func (h *Headers) Time(name string) (time.Time, error) {
+	values, ok := h.Get(name)
+	if !ok || len(values) == 0 {
+		return time.Time{}, ErrorNoField
+	}
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		if t, err := time.Parse(layout, values[0]); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrorInvalidValue
+}
+
+// SetTime sets the field called name to t in IMF-fixdate, replacing any
+// value it had.
+func (h *Headers) SetTime(name string, t time.Time) {
+	h.Replace(name, t.UTC().Format(TimeFormat))
+}
+
+// splitQuoted splits s at every sep outside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	parts := []string{}
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case quoted && b == '\\':
+			i++
+		case b == '"':
+			quoted = !quoted
+		case !quoted && b == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns the content of a quoted string with its escapes removed,
+// or s itself if it is not quoted.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
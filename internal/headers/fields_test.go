@@ -0,0 +1,123 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"gzip", "br"}, SplitList("gzip, br"))
+	assert.Equal(t, []string{"a", "b"}, SplitList(" ,a,, \tb , "))
+	assert.Equal(t, []string{}, SplitList(""))
+
+	// Test: Commas in quoted strings, including after an escaped quote
+	assert.Equal(t,
+		[]string{`W/"a,b"`, `"x\",y"`, "z"},
+		SplitList(`W/"a,b", "x\",y", z`))
+}
+
+func TestElements(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Cache-Control", "no-cache, max-age=0")
+	h.Set("cache-control", `private="set-cookie, x-id"`)
+
+	assert.Equal(t,
+		[]string{"no-cache", "max-age=0", `private="set-cookie, x-id"`},
+		h.Elements("CACHE-CONTROL"))
+	assert.Equal(t, []string{}, h.Elements("pragma"))
+}
+
+func TestParams(t *testing.T) {
+	value, params := SplitParams(`text/html; Charset=utf-8 ;boundary="a;b \"c\""; novalue; =x`)
+	assert.Equal(t, "text/html", value)
+	assert.Equal(t, Params{"charset": "utf-8", "boundary": `a;b "c"`}, params)
+
+	h := NewHeaders()
+	h.Set("Content-Type", "application/json")
+	value, params, ok := h.Params("content-type")
+	require.True(t, ok)
+	assert.Equal(t, "application/json", value)
+	assert.Empty(t, params)
+
+	_, _, ok = h.Params("content-disposition")
+	assert.False(t, ok)
+}
+
+func TestWeighted(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Accept", "text/html;level=1;q=0.5, application/json, */*;q=0.1")
+	h.Set("Accept", "text/plain;q=0.5, image/png;q=1.5, image/gif;q=0")
+
+	assert.Equal(t, []Weighted{
+		{Value: "application/json", Params: Params{}, Q: 1},
+		{Value: "text/html", Params: Params{"level": "1"}, Q: 0.5},
+		{Value: "text/plain", Params: Params{}, Q: 0.5},
+		{Value: "*/*", Params: Params{}, Q: 0.1},
+		{Value: "image/gif", Params: Params{}, Q: 0},
+	}, h.Weighted("accept"))
+}
+
+func TestParseQValue(t *testing.T) {
+	for _, v := range []string{"0", "1", "0.5", "0.123", "1.000", "0."} {
+		_, ok := parseQValue(v)
+		assert.True(t, ok, v)
+	}
+	for _, v := range []string{"", "2", "1.5", "0.1234", ".5", "0,5", "1.001", "-0"} {
+		_, ok := parseQValue(v)
+		assert.False(t, ok, v)
+	}
+}
+
+func TestInt(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Content-Length", "42")
+	h.Set("Max-Forwards", "3, 3")
+	h.Set("Age", "1, 2")
+	h.Set("X-Count", "-1")
+	h.Set("X-Big", "1234567890123456789")
+	h.Set("X-Empty", "")
+
+	n, err := h.Int("content-length")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+
+	n, err = h.Int("max-forwards")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	for _, name := range []string{"age", "x-count", "x-big", "x-empty"} {
+		_, err = h.Int(name)
+		assert.ErrorIs(t, err, ErrorInvalidValue, name)
+	}
+	_, err = h.Int("x-missing")
+	assert.ErrorIs(t, err, ErrorNoField)
+}
+
+func TestTime(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	for _, v := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		h := NewHeaders()
+		h.Set("Last-Modified", v)
+		got, err := h.Time("last-modified")
+		require.NoError(t, err, v)
+		assert.True(t, want.Equal(got), v)
+	}
+
+	h := NewHeaders()
+	h.Set("Date", "yesterday")
+	_, err := h.Time("date")
+	assert.ErrorIs(t, err, ErrorInvalidValue)
+	_, err = h.Time("expires")
+	assert.ErrorIs(t, err, ErrorNoField)
+
+	h.SetTime("Date", want.In(time.FixedZone("CET", 3600)))
+	assert.Equal(t, []string{"Sun, 06 Nov 1994 08:49:37 GMT"}, values(h, "date"))
+}
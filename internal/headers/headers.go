@@ -3,13 +3,27 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"strings"
 )
 
-type Headers map[string][]string
+// Headers is a field section. Names are matched case-insensitively, while
+// fields keep the casing of the name they were first added with and the
+// order they were first added in, which is the order ForEach visits them and
+// a response writes them. The zero value is an empty section ready to use.
+type Headers struct {
+	// fields maps lowercase names to entries in order.
+	fields map[string]*field
+	order  []*field
+}
+
+type field struct {
+	name   string
+	values []string
+}
 
-func NewHeaders() Headers {
-	return map[string][]string{}
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
 var sep = []byte("\r\n")
@@ -18,71 +32,228 @@ var (
 	ErrorNoFieldName      = fmt.Errorf("no field name: malformed request")
 	ErrorInvalidFieldName = fmt.Errorf("invalid field name")
 	ErrorInvalidCharacter = fmt.Errorf("invalid character")
+	ErrorObsFold          = fmt.Errorf("obsolete line folding")
+	ErrorSpaceBeforeColon = fmt.Errorf("whitespace before colon")
+)
+
+// ParseError is returned for a message that cannot be parsed. It carries
+// the HTTP status code a server should answer with, the byte offset of the
+// offending data and a reason that can be shown to the client. Err is the
+// sentinel error describing the kind of failure, for use with errors.Is.
+type ParseError struct {
+	Status int
+	Offset int
+	Reason string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at byte %d: %s", e.Err, e.Offset, e.Reason)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ObsFoldPolicy decides what ParseWith does with obsolete line folding: a
+// field line that continues the previous one by starting with whitespace.
+type ObsFoldPolicy int
+
+const (
+	// ObsFoldReject rejects a folded field with ErrorObsFold.
+	ObsFoldReject ObsFoldPolicy = iota
+	// ObsFoldUnfold replaces each fold with a single space, as RFC 9112
+	// section 5.2 allows.
+	ObsFoldUnfold
 )
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// ParseOptions relaxes how ParseWith reads a field section. The zero value
+// is as strict as Parse.
+type ParseOptions struct {
+	ObsFold ObsFoldPolicy
+	// AllowBareLF accepts a lone LF as a line terminator in addition to
+	// CRLF, as RFC 9112 section 2.2 permits.
+	AllowBareLF bool
+	// OnField, if set, is called for each field added, with the offset in
+	// data of the line it was read from.
+	OnField func(name string, offset int)
+}
+
+// Parse parses field lines from data until the empty line ending the
+// section, adding them to h. It returns the number of bytes consumed and
+// whether the section is complete; a partial line is left for the next call.
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWith(data, ParseOptions{})
+}
+
+// ParseWith is like Parse, with the leniencies enabled in opts.
+func (h *Headers) ParseWith(data []byte, opts ParseOptions) (n int, done bool, err error) {
 	read := 0
 
 	for {
-		idx := bytes.Index(data[read:], sep)
+		idx, sepLen := lineEnd(data[read:], opts.AllowBareLF)
 		if idx == -1 {
 			return read, false, nil
 		}
 
 		if idx == 0 {
-			read += len(sep)
+			read += sepLen
 			return read, true, nil
 		}
 
 		line := data[read : read+idx]
+		next := read + idx + sepLen
+
+		// A line starting with whitespace continues the previous field
+		// (obs-fold), or, as the first line, hides a field from parsers
+		// that skip it. Either way peers may disagree on its meaning, so
+		// unless folds are unfolded with the line they belong to, it is an
+		// error.
+		if isWhitespace(line[0]) {
+			return read, false, &ParseError{
+				Status: 400,
+				Offset: read,
+				Reason: "header line starts with whitespace (obsolete line folding)",
+				Err:    ErrorObsFold,
+			}
+		}
+
+		folded := false
+		if opts.ObsFold == ObsFoldUnfold {
+			var complete bool
+			line, next, folded, complete = unfold(data, read, idx, next, opts.AllowBareLF)
+			if !complete {
+				return read, false, nil
+			}
+		}
 
 		name, value, parseErr := parseHeader(line)
 		if parseErr != nil {
+			if folded {
+				// Offsets within an unfolded line do not map back to data.
+				parseErr.Offset = 0
+			}
+			parseErr.Offset += read
 			return read, false, parseErr
 		}
 
-		val, ok := h[strings.ToLower(name)]
+		h.Set(name, value)
+		if opts.OnField != nil {
+			opts.OnField(name, read)
+		}
+		read = next
+	}
+}
 
-		if !ok {
-			h[strings.ToLower(name)] = []string{value}
-		} else {
-			h[strings.ToLower(name)] = append(val, value)
+// unfold returns the field line of idx bytes at data[start:], whose
+// successor starts at next, joined with the continuation lines that follow
+// it, and the offset of the first line after them. Since a continuation can
+// only be told apart by its first byte, complete is false until that byte of
+// the following line is in data.
+func unfold(data []byte, start, idx, next int, bareLF bool) (line []byte, end int, folded, complete bool) {
+	line = data[start : start+idx]
+
+	for {
+		if next >= len(data) {
+			return nil, 0, false, false
+		}
+		if !isWhitespace(data[next]) {
+			return line, next, folded, true
 		}
 
-		read += idx + len(sep)
+		n, sepLen := lineEnd(data[next:], bareLF)
+		if n == -1 {
+			return nil, 0, false, false
+		}
+
+		joined := make([]byte, 0, len(line)+1+n)
+		joined = append(joined, bytes.TrimRight(line, " \t")...)
+		joined = append(joined, ' ')
+		joined = append(joined, bytes.TrimLeft(data[next:next+n], " \t")...)
+		line = joined
+		folded = true
+		next += n + sepLen
 	}
 }
 
-func (h Headers) Get(name string) ([]string, bool) {
-	n, ok := h[strings.ToLower(name)]
-	return n, ok
+// lineEnd returns the length of the line at the start of data and of its
+// terminator, or -1 if data holds no complete line.
+func lineEnd(data []byte, bareLF bool) (int, int) {
+	if !bareLF {
+		return bytes.Index(data, sep), len(sep)
+	}
+	i := bytes.IndexByte(data, '\n')
+	if i > 0 && data[i-1] == '\r' {
+		return i - 1, len(sep)
+	}
+	return i, 1
 }
 
-func (h Headers) Set(name, value string) {
-	if v, ok := h[name]; ok {
-		h[name] = append(v, value)
-	} else {
-		h[name] = []string{value}
+// Get returns the values of the field called name, in any casing. A nil
+// *Headers has no fields.
+func (h *Headers) Get(name string) ([]string, bool) {
+	if h == nil {
+		return nil, false
 	}
+	f, ok := h.fields[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return f.values, true
 }
 
-func (h Headers) Delete(name string) {
-	name = strings.ToLower(name)
-	delete(h, name)
+// Set adds value to the field called name, appending the field if it is
+// not present yet.
+func (h *Headers) Set(name, value string) {
+	key := strings.ToLower(name)
+	if f, ok := h.fields[key]; ok {
+		f.values = append(f.values, value)
+		return
+	}
+
+	if h.fields == nil {
+		h.fields = map[string]*field{}
+	}
+	f := &field{name: name, values: []string{value}}
+	h.fields[key] = f
+	h.order = append(h.order, f)
 }
 
-func (h Headers) Replace(name, value string) {
-	if _, ok := h.Get(name); ok {
-		h.Delete(name)
-		h.Set(name, value)
-	} else {
-		h.Set(name, value)
+func (h *Headers) Delete(name string) {
+	key := strings.ToLower(name)
+	f, ok := h.fields[key]
+	if !ok {
+		return
 	}
+	delete(h.fields, key)
+	h.order = slices.DeleteFunc(h.order, func(o *field) bool { return o == f })
 }
 
-func (h Headers) ForEach(cb func(string, []string)) {
-	for n, v := range h {
-		cb(n, v)
+// Replace sets value as the only value of the field called name. A present
+// field keeps its place and casing.
+func (h *Headers) Replace(name, value string) {
+	if f, ok := h.fields[strings.ToLower(name)]; ok {
+		f.values = []string{value}
+		return
+	}
+	h.Set(name, value)
+}
+
+// Len returns the number of fields.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.order)
+}
+
+// ForEach calls cb with the name and values of every field, in order.
+func (h *Headers) ForEach(cb func(string, []string)) {
+	if h == nil {
+		return
+	}
+	for _, f := range h.order {
+		cb(f.name, f.values)
 	}
 }
 
@@ -99,25 +270,68 @@ func isValidFieldName(name []byte) bool {
 	return true
 }
 
-func parseHeader(line []byte) (string, string, error) {
-	parts := bytes.SplitN(line, []byte(":"), 2)
-
-	if len(parts) != 2 {
-		return "", "", ErrorNoFieldName
+func parseHeader(line []byte) (string, string, *ParseError) {
+	colon := bytes.IndexByte(line, ':')
+	if colon == -1 {
+		return "", "", &ParseError{
+			Status: 400,
+			Reason: "header line has no colon separating name and value",
+			Err:    ErrorNoFieldName,
+		}
 	}
 
-	fieldName := parts[0]
-	fieldValue := parts[1]
-
-	fieldName = bytes.TrimSpace(fieldName)
-	fieldValue = bytes.TrimSpace(fieldValue)
-
+	fieldName := line[:colon]
 	if len(fieldName) == 0 {
-		return "", "", ErrorNoFieldName
+		return "", "", &ParseError{
+			Status: 400,
+			Reason: "header line has an empty field name",
+			Err:    ErrorNoFieldName,
+		}
+	}
+
+	// RFC 9112 section 5.1 forbids whitespace between the name and the
+	// colon, which parsers that trim it and those that keep it would read
+	// as different fields.
+	if trimmed := bytes.TrimRight(fieldName, " \t"); len(trimmed) < len(fieldName) {
+		return "", "", &ParseError{
+			Status: 400,
+			Offset: len(trimmed),
+			Reason: fmt.Sprintf("whitespace between header field name %q and colon", trimmed),
+			Err:    ErrorSpaceBeforeColon,
+		}
 	}
 
 	if !isValidFieldName(fieldName) {
-		return "", "", ErrorInvalidFieldName
+		return "", "", &ParseError{
+			Status: 400,
+			Reason: fmt.Sprintf("header field name %q contains characters not allowed in a token", fieldName),
+			Err:    ErrorInvalidFieldName,
+		}
 	}
+
+	rawValue := line[colon+1:]
+	valueStart := colon + 1 + len(rawValue) - len(bytes.TrimLeft(rawValue, " \t"))
+	fieldValue := bytes.Trim(rawValue, " \t")
+	for i, b := range fieldValue {
+		if !isFieldValueByte(b) {
+			return "", "", &ParseError{
+				Status: 400,
+				Offset: valueStart + i,
+				Reason: fmt.Sprintf("value of header field %q contains control character 0x%02x", fieldName, b),
+				Err:    ErrorInvalidCharacter,
+			}
+		}
+	}
+
 	return string(fieldName), string(fieldValue), nil
 }
+
+// isFieldValueByte reports whether b may appear in a field value: VCHAR,
+// obs-text, or SP and HTAB between them.
+func isFieldValueByte(b byte) bool {
+	return b == '\t' || (b >= ' ' && b != 0x7f)
+}
+
+func isWhitespace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
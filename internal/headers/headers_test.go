@@ -47,7 +47,7 @@ func min(a, b, c int) int {
 	return c
 }
 
-func parseHeadersFromChunks(data []byte, chunkSize int) (Headers, int, bool, error) {
+func parseHeadersFromChunks(data []byte, chunkSize int) (*Headers, int, bool, error) {
 	reader := newChunkReader(data, chunkSize)
 	headers := NewHeaders()
 
@@ -85,6 +85,11 @@ func parseHeadersFromChunks(data []byte, chunkSize int) (Headers, int, bool, err
 	}
 }
 
+func values(h *Headers, name string) []string {
+	v, _ := h.Get(name)
+	return v
+}
+
 func TestHeadersParse_Basic(t *testing.T) {
 	data := []byte("Host: localhost\r\n\r\n")
 
@@ -92,7 +97,7 @@ func TestHeadersParse_Basic(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, done)
 
-	assert.Equal(t, []string{"localhost"}, headers["host"])
+	assert.Equal(t, []string{"localhost"}, values(headers, "host"))
 	assert.Equal(t, len(data), n)
 }
 
@@ -107,9 +112,9 @@ func TestHeadersParse_MultipleHeaders(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, done)
 
-	assert.Equal(t, []string{"api.example.com"}, headers["host"])
-	assert.Equal(t, []string{"application/json"}, headers["content-type"])
-	assert.Equal(t, []string{"curl/8.0"}, headers["user-agent"])
+	assert.Equal(t, []string{"api.example.com"}, values(headers, "host"))
+	assert.Equal(t, []string{"application/json"}, values(headers, "content-type"))
+	assert.Equal(t, []string{"curl/8.0"}, values(headers, "user-agent"))
 	assert.Equal(t, len(data), n)
 }
 
@@ -123,7 +128,7 @@ func TestHeadersParse_RepeatedHeader(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, done)
 
-	assert.Equal(t, []string{"a=1", "b=2"}, headers["set-cookie"])
+	assert.Equal(t, []string{"a=1", "b=2"}, values(headers, "set-cookie"))
 	assert.Equal(t, len(data), n)
 }
 
@@ -132,7 +137,7 @@ func TestHeadersParse_Invalid_NoColon(t *testing.T) {
 
 	headers, n, done, err := parseHeadersFromChunks(data, 10)
 	require.Error(t, err)
-	assert.Equal(t, ErrorNoFieldName, err)
+	assert.ErrorIs(t, err, ErrorNoFieldName)
 	assert.False(t, done)
 	assert.Equal(t, 0, n)
 	assert.Empty(t, headers)
@@ -143,7 +148,7 @@ func TestHeadersParse_Invalid_EmptyFieldName(t *testing.T) {
 
 	headers, n, done, err := parseHeadersFromChunks(data, 10)
 	require.Error(t, err)
-	assert.Equal(t, ErrorNoFieldName, err)
+	assert.ErrorIs(t, err, ErrorNoFieldName)
 	assert.False(t, done)
 	assert.Equal(t, 0, n)
 	assert.Empty(t, headers)
@@ -156,7 +161,7 @@ func TestHeadersParse_Incomplete(t *testing.T) {
 	require.NoError(t, err)
 	assert.False(t, done)
 
-	assert.Equal(t, []string{"localhost"}, headers["host"])
+	assert.Equal(t, []string{"localhost"}, values(headers, "host"))
 	assert.Equal(t, 17, n) // "Host: localhost\r\n"
 }
 
@@ -167,7 +172,7 @@ func TestHeadersParse_ChunkBoundary(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, done)
 
-	assert.Equal(t, []string{"Bearer token"}, headers["authorization"])
+	assert.Equal(t, []string{"Bearer token"}, values(headers, "authorization"))
 	assert.Equal(t, len(data), n)
 }
 
@@ -178,6 +183,214 @@ func TestHeadersParse_EmptyHeaders(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, done)
 
-	assert.Equal(t, 0, len(headers))
+	assert.Equal(t, 0, headers.Len())
+	assert.Equal(t, len(data), n)
+}
+
+func TestHeadersParse_ParseError(t *testing.T) {
+	data := []byte("Host: localhost\r\nBad Name: value\r\n\r\n")
+
+	_, n, done, err := parseHeadersFromChunks(data, 64)
+	require.Error(t, err)
+	assert.False(t, done)
+	assert.Equal(t, 17, n)
+
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrorInvalidFieldName)
+	assert.Equal(t, 400, perr.Status)
+	assert.Equal(t, 17, perr.Offset)
+	assert.Contains(t, perr.Reason, `"Bad Name"`)
+}
+
+func TestHeadersParse_ObsFold(t *testing.T) {
+	data := []byte("X-Long: first\r\n  second\r\n\r\n")
+
+	headers, n, done, err := parseHeadersFromChunks(data, 64)
+	assert.False(t, done)
+	assert.Equal(t, 15, n)
+	assert.Equal(t, []string{"first"}, values(headers, "x-long"))
+
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrorObsFold)
+	assert.Equal(t, 400, perr.Status)
+	assert.Equal(t, 15, perr.Offset)
+
+	// A leading space must not hide a field either
+	_, _, _, err = parseHeadersFromChunks([]byte(" Content-Length: 5\r\n\r\n"), 64)
+	assert.ErrorIs(t, err, ErrorObsFold)
+}
+
+func TestHeadersParse_InvalidValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		offset int
+	}{
+		{"NUL", "X-Token: ab\x00cd\r\n\r\n", 11},
+		{"bare CR", "X-Token: ab\rcd\r\n\r\n", 11},
+		{"DEL", "X-Token: \x7f\r\n\r\n", 9},
+		{"vertical tab", "X-Token:\x0bvalue\r\n\r\n", 8},
+	}
+
+	for _, tt := range tests {
+		_, _, _, err := parseHeadersFromChunks([]byte(tt.data), 64)
+		var perr *ParseError
+		require.ErrorAs(t, err, &perr, tt.name)
+		assert.ErrorIs(t, err, ErrorInvalidCharacter, tt.name)
+		assert.Equal(t, 400, perr.Status, tt.name)
+		assert.Equal(t, tt.offset, perr.Offset, tt.name)
+	}
+
+	// Test: Tabs, spaces and obs-text inside a value are allowed
+	headers, _, done, err := parseHeadersFromChunks([]byte("X-Name:\tJos\xc3\xa9 \t Doe \r\n\r\n"), 64)
+	require.NoError(t, err)
+	require.True(t, done)
+	assert.Equal(t, []string{"Jos\xc3\xa9 \t Doe"}, values(headers, "x-name"))
+}
+
+func TestHeadersParse_SpaceBeforeColon(t *testing.T) {
+	for _, data := range []string{"Host : localhost\r\n\r\n", "Host\t: localhost\r\n\r\n"} {
+		_, _, _, err := parseHeadersFromChunks([]byte(data), 64)
+		var perr *ParseError
+		require.ErrorAs(t, err, &perr, data)
+		assert.ErrorIs(t, err, ErrorSpaceBeforeColon, data)
+		assert.Equal(t, 400, perr.Status, data)
+		assert.Equal(t, 4, perr.Offset, data)
+	}
+}
+
+func TestHeadersParse_Unfold(t *testing.T) {
+	data := []byte("X-Long: first  \r\n  second\r\n\tthird\r\nHost: localhost\r\n\r\n")
+	opts := ParseOptions{ObsFold: ObsFoldUnfold}
+
+	// Test: Fed a byte at a time, a line is only taken once the first byte
+	// of the following one shows it is not continued.
+	headers := NewHeaders()
+	buf := []byte{}
+	total := 0
+	done := false
+	for i := 0; i < len(data) && !done; i++ {
+		buf = append(buf, data[i])
+		n, d, err := headers.ParseWith(buf, opts)
+		require.NoError(t, err)
+		buf = buf[n:]
+		total += n
+		done = d
+	}
+	require.True(t, done)
+	assert.Equal(t, len(data), total)
+	assert.Equal(t, []string{"first second third"}, values(headers, "x-long"))
+	assert.Equal(t, []string{"localhost"}, values(headers, "host"))
+
+	// Test: A leading space with no field to continue is still rejected
+	_, _, err := NewHeaders().ParseWith([]byte(" X-Long: value\r\n\r\n"), opts)
+	assert.ErrorIs(t, err, ErrorObsFold)
+}
+
+func TestHeadersParse_BareLF(t *testing.T) {
+	data := []byte("Host: localhost\nX-Long: one\n two\r\n\n")
+	opts := ParseOptions{ObsFold: ObsFoldUnfold, AllowBareLF: true}
+
+	headers := NewHeaders()
+	n, done, err := headers.ParseWith(data, opts)
+	require.NoError(t, err)
+	require.True(t, done)
 	assert.Equal(t, len(data), n)
+	assert.Equal(t, []string{"localhost"}, values(headers, "host"))
+	assert.Equal(t, []string{"one two"}, values(headers, "x-long"))
+
+	// Test: Without the option, a bare LF does not end a line
+	n, done, err = NewHeaders().Parse([]byte("Host: localhost\n\n"))
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, 0, n)
+}
+
+func TestHeadersParse_OnField(t *testing.T) {
+	type line struct {
+		name   string
+		offset int
+	}
+	lines := []line{}
+	opts := ParseOptions{
+		ObsFold: ObsFoldUnfold,
+		OnField: func(name string, offset int) {
+			lines = append(lines, line{name, offset})
+		},
+	}
+
+	data := []byte("Host: localhost\r\nX-Long: one\r\n two\r\nhost: other\r\n\r\n")
+	_, done, err := NewHeaders().ParseWith(data, opts)
+	require.NoError(t, err)
+	require.True(t, done)
+	assert.Equal(t, []line{{"Host", 0}, {"X-Long", 17}, {"host", 36}}, lines)
+}
+
+func TestHeaders_CanonicalKeys(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Trailer", "X-One")
+	h.Set("trailer", "X-Two")
+	h.Set("TRAILER", "X-Three")
+
+	assert.Equal(t, 1, h.Len())
+	assert.Equal(t, []string{"X-One", "X-Two", "X-Three"}, values(h, "Trailer"))
+
+	// Test: The first casing is kept
+	var names []string
+	h.ForEach(func(n string, v []string) { names = append(names, n) })
+	assert.Equal(t, []string{"Trailer"}, names)
+
+	h.Delete("tRaIlEr")
+	_, ok := h.Get("trailer")
+	assert.False(t, ok)
+	assert.Equal(t, 0, h.Len())
+}
+
+func TestHeaders_Order(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Content-Type", "text/plain")
+	h.Set("X-Request-Id", "abc")
+	h.Set("cache-control", "no-store")
+	h.Set("x-request-id", "def")
+	h.Replace("content-type", "text/html")
+	h.Delete("X-Request-ID")
+	h.Set("X-Request-ID", "ghi")
+
+	type entry struct {
+		name   string
+		values []string
+	}
+	var got []entry
+	h.ForEach(func(n string, v []string) { got = append(got, entry{n, v}) })
+	assert.Equal(t, []entry{
+		{"Content-Type", []string{"text/html"}},
+		{"cache-control", []string{"no-store"}},
+		{"X-Request-ID", []string{"ghi"}},
+	}, got)
+}
+
+func TestHeadersParse_KeepsCasingAndOrder(t *testing.T) {
+	headers := NewHeaders()
+	_, done, err := headers.Parse([]byte("Host: localhost\r\nX-Trace: 1\r\nhost: other\r\naccept: */*\r\n\r\n"))
+	require.NoError(t, err)
+	require.True(t, done)
+
+	var names []string
+	headers.ForEach(func(n string, v []string) { names = append(names, n) })
+	assert.Equal(t, []string{"Host", "X-Trace", "accept"}, names)
+	assert.Equal(t, []string{"localhost", "other"}, values(headers, "HOST"))
+}
+
+func TestHeaders_ZeroAndNil(t *testing.T) {
+	var nilHeaders *Headers
+	_, ok := nilHeaders.Get("host")
+	assert.False(t, ok)
+	assert.Equal(t, 0, nilHeaders.Len())
+	nilHeaders.ForEach(func(string, []string) { t.Fatal("nil headers have no fields") })
+
+	var h Headers
+	h.Set("Host", "localhost")
+	assert.Equal(t, []string{"localhost"}, values(&h, "host"))
 }
@@ -0,0 +1,661 @@
+package headers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Structured Field Values, RFC 8941. A bare item is one of:
+//
+//	int64    Integer
+//	float64  Decimal
+//	string   String
+//	Token    Token
+//	[]byte   Byte Sequence
+//	bool     Boolean
+//
+// Serialization also takes an int for an Integer.
+
+var ErrorStructuredField = fmt.Errorf("invalid structured field value")
+
+// Token is a bare item serialized without quotes, such as a media type.
+type Token string
+
+// Parameter is a key and bare item attached to an Item or InnerList.
+type Parameter struct {
+	Key   string
+	Value any
+}
+
+// Parameters are kept in the order they were parsed or added.
+type Parameters []Parameter
+
+// Get returns the value of the parameter key.
+func (p Parameters) Get(key string) (any, bool) {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Member is a member of a List or Dictionary: an Item or an InnerList.
+type Member interface {
+	isMember()
+}
+
+// Item is a bare item with parameters.
+type Item struct {
+	Value  any
+	Params Parameters
+}
+
+// InnerList is a parenthesized list of items with parameters.
+type InnerList struct {
+	Items  []Item
+	Params Parameters
+}
+
+func (Item) isMember()      {}
+func (InnerList) isMember() {}
+
+type List []Member
+
+// DictMember is a key and member of a Dictionary.
+type DictMember struct {
+	Key   string
+	Value Member
+}
+
+// Dictionary members are kept in order, each key at most once.
+type Dictionary []DictMember
+
+// Get returns the member with the given key.
+func (d Dictionary) Get(key string) (Member, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ParseItem parses a field value holding an Item.
+func ParseItem(value string) (Item, error) {
+	p := sfParser{s: value}
+	p.skipSP()
+	item, err := p.item()
+	if err != nil {
+		return Item{}, err
+	}
+	return item, p.end()
+}
+
+// ParseList parses a field value holding a List. An empty value is an empty
+// List.
+func ParseList(value string) (List, error) {
+	p := sfParser{s: value}
+	p.skipSP()
+
+	list := List{}
+	for !p.done() {
+		member, err := p.member()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, member)
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+	return list, p.end()
+}
+
+// ParseDictionary parses a field value holding a Dictionary. An empty value
+// is an empty Dictionary. Of a key given more than once, the last value is
+// kept, at the position of the first.
+func ParseDictionary(value string) (Dictionary, error) {
+	p := sfParser{s: value}
+	p.skipSP()
+
+	dict := Dictionary{}
+	for !p.done() {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		var member Member
+		if p.peek() == '=' {
+			p.i++
+			if member, err = p.member(); err != nil {
+				return nil, err
+			}
+		} else {
+			params, err := p.parameters()
+			if err != nil {
+				return nil, err
+			}
+			member = Item{Value: true, Params: params}
+		}
+		dict = dict.set(key, member)
+
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+	return dict, p.end()
+}
+
+func (d Dictionary) set(key string, member Member) Dictionary {
+	for i := range d {
+		if d[i].Key == key {
+			d[i].Value = member
+			return d
+		}
+	}
+	return append(d, DictMember{Key: key, Value: member})
+}
+
+// StructuredItem parses the field called name as an Item.
+func (h *Headers) StructuredItem(name string) (Item, error) {
+	value, err := h.combined(name)
+	if err != nil {
+		return Item{}, err
+	}
+	return ParseItem(value)
+}
+
+// StructuredList parses the field called name, across all of its lines, as
+// a List.
+func (h *Headers) StructuredList(name string) (List, error) {
+	value, err := h.combined(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseList(value)
+}
+
+// StructuredDictionary parses the field called name, across all of its
+// lines, as a Dictionary.
+func (h *Headers) StructuredDictionary(name string) (Dictionary, error) {
+	value, err := h.combined(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDictionary(value)
+}
+
+// combined joins the lines of the field called name, as RFC 8941 section
+// 4.2 requires before parsing.
+func (h *Headers) combined(name string) (string, error) {
+	values, ok := h.Get(name)
+	if !ok {
+		return "", ErrorNoField
+	}
+	return strings.Join(values, ", "), nil
+}
+
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) done() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) fail(format string, a ...any) error {
+	return fmt.Errorf("%w at byte %d: %s", ErrorStructuredField, p.i, fmt.Sprintf(format, a...))
+}
+
+func (p *sfParser) skipSP() {
+	for p.peek() == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.i++
+	}
+}
+
+// end checks that nothing but spaces follows the value.
+func (p *sfParser) end() error {
+	p.skipSP()
+	if !p.done() {
+		return p.fail("unexpected %q", p.peek())
+	}
+	return nil
+}
+
+// nextMember consumes the comma between two members of a List or
+// Dictionary, or stops at the end of the value.
+func (p *sfParser) nextMember() error {
+	p.skipOWS()
+	if p.done() {
+		return nil
+	}
+	if p.peek() != ',' {
+		return p.fail("expected comma, found %q", p.peek())
+	}
+	p.i++
+	p.skipOWS()
+	if p.done() {
+		return p.fail("trailing comma")
+	}
+	return nil
+}
+
+func (p *sfParser) member() (Member, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *sfParser) innerList() (InnerList, error) {
+	p.i++ // (
+
+	list := InnerList{Items: []Item{}}
+	for {
+		p.skipSP()
+		if p.done() {
+			return InnerList{}, p.fail("unterminated inner list")
+		}
+		if p.peek() == ')' {
+			p.i++
+			params, err := p.parameters()
+			if err != nil {
+				return InnerList{}, err
+			}
+			list.Params = params
+			return list, nil
+		}
+
+		item, err := p.item()
+		if err != nil {
+			return InnerList{}, err
+		}
+		list.Items = append(list.Items, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return InnerList{}, p.fail("expected space or ')' in inner list")
+		}
+	}
+}
+
+func (p *sfParser) item() (Item, error) {
+	value, err := p.bareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	params, err := p.parameters()
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: value, Params: params}, nil
+}
+
+func (p *sfParser) parameters() (Parameters, error) {
+	params := Parameters{}
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		var value any = true
+		if p.peek() == '=' {
+			p.i++
+			if value, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = params.set(key, value)
+	}
+	return params, nil
+}
+
+func (p Parameters) set(key string, value any) Parameters {
+	for i := range p {
+		if p[i].Key == key {
+			p[i].Value = value
+			return p
+		}
+	}
+	return append(p, Parameter{Key: key, Value: value})
+}
+
+func (p *sfParser) key() (string, error) {
+	start := p.i
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.fail("key must start with a lowercase letter or '*'")
+	}
+	for p.i++; !p.done() && isKeyChar(p.peek()); p.i++ {
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == '*' || isAlpha(c):
+		return p.token(), nil
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		return p.boolean()
+	case p.done():
+		return nil, p.fail("missing item")
+	default:
+		return nil, p.fail("unexpected %q", c)
+	}
+}
+
+func (p *sfParser) number() (any, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	if !isDigit(p.peek()) {
+		return nil, p.fail("number without digits")
+	}
+
+	digits, dot := 0, -1
+	for ; !p.done(); p.i++ {
+		c := p.peek()
+		if c == '.' && dot == -1 {
+			if digits > 12 {
+				return nil, p.fail("decimal with more than 12 integer digits")
+			}
+			dot = digits
+			continue
+		}
+		if !isDigit(c) {
+			break
+		}
+		digits++
+		if dot == -1 && digits > 15 {
+			return nil, p.fail("integer with more than 15 digits")
+		}
+	}
+
+	num := p.s[start:p.i]
+	if dot == -1 {
+		return strconv.ParseInt(num, 10, 64)
+	}
+	if frac := digits - dot; frac == 0 || frac > 3 {
+		return nil, p.fail("decimal must have 1 to 3 fractional digits")
+	}
+	return strconv.ParseFloat(num, 64)
+}
+
+func (p *sfParser) string() (string, error) {
+	p.i++ // "
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.i++
+		switch {
+		case c == '\\':
+			if next := p.peek(); next != '"' && next != '\\' {
+				return "", p.fail("invalid escape in string")
+			}
+			b.WriteByte(p.peek())
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			p.i--
+			return "", p.fail("invalid character 0x%02x in string", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.fail("unterminated string")
+}
+
+func (p *sfParser) token() Token {
+	start := p.i
+	for p.i++; !p.done() && isTokenChar(p.peek()); p.i++ {
+	}
+	return Token(p.s[start:p.i])
+}
+
+func (p *sfParser) byteSequence() ([]byte, error) {
+	p.i++ // :
+
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end == -1 {
+		return nil, p.fail("unterminated byte sequence")
+	}
+	encoded := p.s[p.i : p.i+end]
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, p.fail("invalid base64 in byte sequence")
+	}
+	p.i += end + 1
+	return data, nil
+}
+
+func (p *sfParser) boolean() (bool, error) {
+	p.i++ // ?
+
+	switch p.peek() {
+	case '1':
+		p.i++
+		return true, nil
+	case '0':
+		p.i++
+		return false, nil
+	}
+	return false, p.fail("boolean must be ?0 or ?1")
+}
+
+// SerializeItem returns item as a field value.
+func SerializeItem(item Item) (string, error) {
+	var b strings.Builder
+	if err := writeItem(&b, item); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// SerializeList returns list as a field value.
+func SerializeList(list List) (string, error) {
+	var b strings.Builder
+	for i, member := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeMember(&b, member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// SerializeDictionary returns dict as a field value.
+func SerializeDictionary(dict Dictionary) (string, error) {
+	var b strings.Builder
+	for i, m := range dict {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeKey(&b, m.Key); err != nil {
+			return "", err
+		}
+
+		// A member that is just true is written as its key.
+		if item, ok := m.Value.(Item); ok && item.Value == true {
+			if err := writeParameters(&b, item.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeMember(&b, m.Value); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func writeMember(b *strings.Builder, member Member) error {
+	switch m := member.(type) {
+	case Item:
+		return writeItem(b, m)
+	case InnerList:
+		b.WriteByte('(')
+		for i, item := range m.Items {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			if err := writeItem(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+		return writeParameters(b, m.Params)
+	}
+	return fmt.Errorf("%w: member of type %T", ErrorStructuredField, member)
+}
+
+func writeItem(b *strings.Builder, item Item) error {
+	if err := writeBareItem(b, item.Value); err != nil {
+		return err
+	}
+	return writeParameters(b, item.Params)
+}
+
+func writeParameters(b *strings.Builder, params Parameters) error {
+	for _, param := range params {
+		b.WriteByte(';')
+		if err := writeKey(b, param.Key); err != nil {
+			return err
+		}
+		if param.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeBareItem(b, param.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeKey(b *strings.Builder, key string) error {
+	if key == "" || (!isLCAlpha(key[0]) && key[0] != '*') {
+		return fmt.Errorf("%w: invalid key %q", ErrorStructuredField, key)
+	}
+	for i := 1; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return fmt.Errorf("%w: invalid key %q", ErrorStructuredField, key)
+		}
+	}
+	b.WriteString(key)
+	return nil
+}
+
+// maxSFInteger bounds Integers to 15 digits.
+const maxSFInteger = 999_999_999_999_999
+
+func writeBareItem(b *strings.Builder, value any) error {
+	switch v := value.(type) {
+	case int:
+		return writeBareItem(b, int64(v))
+	case int64:
+		if v > maxSFInteger || v < -maxSFInteger {
+			return fmt.Errorf("%w: integer %d out of range", ErrorStructuredField, v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		// Decimals have at most three fractional digits, rounded half to even.
+		v = math.RoundToEven(v*1000) / 1000
+		if math.IsNaN(v) || math.Abs(v) >= 1e12 {
+			return fmt.Errorf("%w: decimal %v out of range", ErrorStructuredField, v)
+		}
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		b.WriteString(s)
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("%w: invalid character 0x%02x in string", ErrorStructuredField, c)
+			}
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	case Token:
+		if v == "" || (!isAlpha(v[0]) && v[0] != '*') {
+			return fmt.Errorf("%w: invalid token %q", ErrorStructuredField, v)
+		}
+		for i := 1; i < len(v); i++ {
+			if !isTokenChar(v[i]) {
+				return fmt.Errorf("%w: invalid token %q", ErrorStructuredField, v)
+			}
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	default:
+		return fmt.Errorf("%w: bare item of type %T", ErrorStructuredField, value)
+	}
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLCAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLCAlpha(c) || (c >= 'A' && c <= 'Z')
+}
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
+
+// isTokenChar reports whether c may follow the first character of a Token:
+// tchar, ':' or '/'.
+func isTokenChar(c byte) bool {
+	return c == ':' || c == '/' || isValidFieldName([]byte{c})
+}
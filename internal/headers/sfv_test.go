@@ -0,0 +1,200 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseItem(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Item
+	}{
+		{"42", Item{Value: int64(42), Params: Parameters{}}},
+		{"-999999999999999", Item{Value: int64(-999999999999999), Params: Parameters{}}},
+		{"4.5", Item{Value: 4.5, Params: Parameters{}}},
+		{"-0.125", Item{Value: -0.125, Params: Parameters{}}},
+		{`"hello \"world\""`, Item{Value: `hello "world"`, Params: Parameters{}}},
+		{"text/html", Item{Value: Token("text/html"), Params: Parameters{}}},
+		{"*foo:bar", Item{Value: Token("*foo:bar"), Params: Parameters{}}},
+		{":aGVsbG8=:", Item{Value: []byte("hello"), Params: Parameters{}}},
+		{"?1", Item{Value: true, Params: Parameters{}}},
+		{"  ?0  ", Item{Value: false, Params: Parameters{}}},
+		{"1; a; b=?0; c=\"x\"; a=2", Item{Value: int64(1), Params: Parameters{
+			{Key: "a", Value: int64(2)},
+			{Key: "b", Value: false},
+			{Key: "c", Value: "x"},
+		}}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseItem(tt.value)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+}
+
+func TestParseItem_Invalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"1234567890123456",
+		"1234567890123.0",
+		"1.2345",
+		"1.",
+		"-",
+		`"unterminated`,
+		`"bad \escape"`,
+		"\"tab\there\"",
+		":not base64:",
+		":aGVsbG8=",
+		"?2",
+		"1;A=2",
+		"1;=2",
+		"1 2",
+		"\t1",
+		"é",
+	} {
+		_, err := ParseItem(value)
+		assert.ErrorIs(t, err, ErrorStructuredField, value)
+	}
+}
+
+func TestParseList(t *testing.T) {
+	list, err := ParseList(`sugar, tea;q=0.5, ("milk" "foo";bar);lvl=1, ()`)
+	require.NoError(t, err)
+	assert.Equal(t, List{
+		Item{Value: Token("sugar"), Params: Parameters{}},
+		Item{Value: Token("tea"), Params: Parameters{{Key: "q", Value: 0.5}}},
+		InnerList{
+			Items: []Item{
+				{Value: "milk", Params: Parameters{}},
+				{Value: "foo", Params: Parameters{{Key: "bar", Value: true}}},
+			},
+			Params: Parameters{{Key: "lvl", Value: int64(1)}},
+		},
+		InnerList{Items: []Item{}, Params: Parameters{}},
+	}, list)
+
+	list, err = ParseList("")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	for _, value := range []string{"a,", "a,,b", "a b", "(a", "(a,b)", "a, (1 2)x"} {
+		_, err := ParseList(value)
+		assert.ErrorIs(t, err, ErrorStructuredField, value)
+	}
+}
+
+func TestParseDictionary(t *testing.T) {
+	dict, err := ParseDictionary(`a=1, b;x=?0, c=(1 2), a=3, d="z"`)
+	require.NoError(t, err)
+	assert.Equal(t, Dictionary{
+		{Key: "a", Value: Item{Value: int64(3), Params: Parameters{}}},
+		{Key: "b", Value: Item{Value: true, Params: Parameters{{Key: "x", Value: false}}}},
+		{Key: "c", Value: InnerList{Items: []Item{
+			{Value: int64(1), Params: Parameters{}},
+			{Value: int64(2), Params: Parameters{}},
+		}, Params: Parameters{}}},
+		{Key: "d", Value: Item{Value: "z", Params: Parameters{}}},
+	}, dict)
+
+	member, ok := dict.Get("b")
+	require.True(t, ok)
+	assert.Equal(t, true, member.(Item).Value)
+	_, ok = dict.Get("e")
+	assert.False(t, ok)
+
+	for _, value := range []string{"A=1", "a=", "a=1,", "a=1 b=2"} {
+		_, err := ParseDictionary(value)
+		assert.ErrorIs(t, err, ErrorStructuredField, value)
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	s, err := SerializeItem(Item{Value: Token("text/html"), Params: Parameters{
+		{Key: "charset", Value: "utf-8"},
+		{Key: "q", Value: 0.5},
+		{Key: "final", Value: true},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, `text/html;charset="utf-8";q=0.5;final`, s)
+
+	s, err = SerializeList(List{
+		Item{Value: 1},
+		Item{Value: 2.0},
+		Item{Value: 1.00049},
+		Item{Value: 0.0005},
+		Item{Value: []byte("hi")},
+		Item{Value: false},
+		InnerList{Items: []Item{{Value: `a"b\c`}, {Value: Token("*")}}, Params: Parameters{{Key: "n", Value: -3}}},
+		InnerList{},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `1, 2.0, 1.0, 0.0, :aGk=:, ?0, ("a\"b\\c" *);n=-3, ()`, s)
+
+	s, err = SerializeDictionary(Dictionary{
+		{Key: "a", Value: Item{Value: true}},
+		{Key: "b", Value: Item{Value: true, Params: Parameters{{Key: "x", Value: int64(1)}}}},
+		{Key: "c", Value: Item{Value: false}},
+		{Key: "d", Value: InnerList{Items: []Item{{Value: Token("y")}}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "a, b;x=1, c=?0, d=(y)", s)
+
+	// Test: Round trip
+	const value = `a=1, b;x=?0, c=(1 2);p="q", d=:AQID:, e=4.25`
+	dict, err := ParseDictionary(value)
+	require.NoError(t, err)
+	s, err = SerializeDictionary(dict)
+	require.NoError(t, err)
+	assert.Equal(t, value, s)
+}
+
+func TestSerialize_Invalid(t *testing.T) {
+	for _, item := range []Item{
+		{Value: int64(1_000_000_000_000_000)},
+		{Value: 1e12},
+		{Value: "café"},
+		{Value: Token("1abc")},
+		{Value: Token("a b")},
+		{Value: uint8(1)},
+		{Value: int64(1), Params: Parameters{{Key: "Upper", Value: true}}},
+	} {
+		_, err := SerializeItem(item)
+		assert.ErrorIs(t, err, ErrorStructuredField, item)
+	}
+
+	_, err := SerializeList(List{nil})
+	assert.ErrorIs(t, err, ErrorStructuredField)
+}
+
+func TestStructuredFields(t *testing.T) {
+	h := NewHeaders()
+	h.Set("Example-List", "a, b")
+	h.Set("example-list", "c")
+	h.Set("Example-Dict", "x=1")
+	h.Set("example-dict", "y")
+	h.Set("Priority", "u=3, i")
+
+	list, err := h.StructuredList("example-list")
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+
+	dict, err := h.StructuredDictionary("Example-Dict")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, []string{dict[0].Key, dict[1].Key})
+
+	priority, err := h.StructuredDictionary("priority")
+	require.NoError(t, err)
+	urgency, _ := priority.Get("u")
+	assert.Equal(t, int64(3), urgency.(Item).Value)
+
+	// Test: An Item field sent twice does not parse
+	_, err = h.StructuredItem("example-list")
+	assert.ErrorIs(t, err, ErrorStructuredField)
+
+	_, err = h.StructuredItem("missing")
+	assert.ErrorIs(t, err, ErrorNoField)
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/mugiwara999/httpfromtcp/internal/server"
+)
+
+// Middleware wraps a handler with behavior that runs around it.
+type Middleware func(server.Handler) server.Handler
+
+// Chain composes middlewares into one. The first middleware is the
+// outermost, so it sees the request first and the outcome last.
+func Chain(mws ...Middleware) Middleware {
+	return func(h server.Handler) server.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}
+}
+
+// status returns the status a request ended with: the one the handler
+// wrote, or the one of the error it returned for the server to write.
+func status(w *response.Writer, herr *server.HandlerError) response.StatusCode {
+	if herr != nil && w.State == response.WriteStateStatusLine {
+		return herr.Status
+	}
+	return w.StatusCode()
+}
+
+// Logger logs the method, target, status and duration of every request.
+func Logger(logger *log.Logger) Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) *server.HandlerError {
+			start := time.Now()
+			herr := next(w, req)
+			logger.Printf("%s %s %d %s",
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				status(w, herr),
+				time.Since(start),
+			)
+			return herr
+		}
+	}
+}
+
+// Recover turns a panic in the handler into a 500 HandlerError, logging the
+// panic value and stack trace. Once the response has started it is too late
+// for a 500, so Recover panics again with server.ErrAbortHandler and the
+// server aborts the connection.
+func Recover(logger *log.Logger) Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) (herr *server.HandlerError) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v != server.ErrAbortHandler {
+					logger.Printf("panic serving %s %s: %v\n%s",
+						req.RequestLine.Method,
+						req.RequestLine.RequestTarget,
+						v,
+						debug.Stack(),
+					)
+				}
+				if w.State != response.WriteStateStatusLine {
+					panic(server.ErrAbortHandler)
+				}
+				herr = &server.HandlerError{
+					Status:  response.StatusInternalServerError,
+					Message: "Internal Server Error",
+				}
+			}()
+			return next(w, req)
+		}
+	}
+}
+
+const requestIDHeader = "x-request-id"
+
+// RequestID makes sure every request carries an X-Request-Id header,
+// generating one when the client sent none, and echoes it in the response.
+func RequestID() Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) *server.HandlerError {
+			id := GetRequestID(req)
+			if id == "" {
+				id = newRequestID()
+				req.Headers.Set(requestIDHeader, id)
+			}
+			w.Header().Set(requestIDHeader, id)
+			return next(w, req)
+		}
+	}
+}
+
+// GetRequestID returns the X-Request-Id of req, or "" if it has none.
+func GetRequestID(req *request.Request) string {
+	if v, ok := req.Headers.Get(requestIDHeader); ok && len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Timing reports how long the handler took for each request, along with the
+// status it ended with, to observe.
+func Timing(observe func(req *request.Request, status response.StatusCode, d time.Duration)) Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) *server.HandlerError {
+			start := time.Now()
+			herr := next(w, req)
+			observe(req, status(w, herr), time.Since(start))
+			return herr
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/mugiwara999/httpfromtcp/internal/headers"
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/mugiwara999/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(target string) *request.Request {
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        "GET",
+			RequestTarget: target,
+			HttpVersion:   "1.1",
+		},
+		Headers: headers.NewHeaders(),
+	}
+}
+
+func ok(w *response.Writer, req *request.Request) *server.HandlerError {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeader(2))
+	w.WriteBody([]byte("ok"))
+	return nil
+}
+
+func TestChain_Order(t *testing.T) {
+	order := []string{}
+	mark := func(name string) Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) *server.HandlerError {
+				order = append(order, name+" in")
+				herr := next(w, req)
+				order = append(order, name+" out")
+				return herr
+			}
+		}
+	}
+
+	h := Chain(mark("a"), mark("b"))(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		order = append(order, "handler")
+		return nil
+	})
+	h(response.NewWriter(&bytes.Buffer{}), newRequest("/"))
+
+	assert.Equal(t, []string{"a in", "b in", "handler", "b out", "a out"}, order)
+}
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	h := Recover(log.New(&logs, "", 0))(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		panic("boom")
+	})
+
+	herr := h(response.NewWriter(&bytes.Buffer{}), newRequest("/panic"))
+	require.NotNil(t, herr)
+	assert.Equal(t, response.StatusInternalServerError, herr.Status)
+	assert.Contains(t, logs.String(), "panic serving GET /panic: boom")
+
+	// Test: Once the response has started, the panic aborts it
+	logs.Reset()
+	h = Recover(log.New(&logs, "", 0))(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.NewHeaders())
+		w.Write([]byte("partial"))
+		panic("boom")
+	})
+
+	assert.PanicsWithValue(t, server.ErrAbortHandler, func() {
+		h(response.NewWriter(&bytes.Buffer{}), newRequest("/partial"))
+	})
+	assert.Contains(t, logs.String(), "panic serving GET /partial: boom")
+}
+
+func TestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	h := Logger(log.New(&logs, "", 0))(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{Status: response.StatusBadRequest, Message: "no"}
+	})
+
+	h(response.NewWriter(&bytes.Buffer{}), newRequest("/bad"))
+	assert.Contains(t, logs.String(), "GET /bad 400 ")
+}
+
+func TestRequestID(t *testing.T) {
+	var out bytes.Buffer
+	req := newRequest("/")
+	RequestID()(ok)(response.NewWriter(&out), req)
+
+	id := GetRequestID(req)
+	assert.Len(t, id, 32)
+	assert.Contains(t, out.String(), "x-request-id: "+id+"\r\n")
+
+	// An ID sent by the client is kept
+	out.Reset()
+	req = newRequest("/")
+	req.Headers.Set("x-request-id", "abc")
+	RequestID()(ok)(response.NewWriter(&out), req)
+	assert.Equal(t, "abc", GetRequestID(req))
+	assert.Contains(t, out.String(), "x-request-id: abc\r\n")
+}
+
+func TestTiming(t *testing.T) {
+	var gotStatus response.StatusCode
+	var gotDuration time.Duration
+	h := Timing(func(req *request.Request, status response.StatusCode, d time.Duration) {
+		gotStatus = status
+		gotDuration = d
+	})(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		time.Sleep(10 * time.Millisecond)
+		return ok(w, req)
+	})
+
+	h(response.NewWriter(&bytes.Buffer{}), newRequest("/"))
+	assert.Equal(t, response.StatusOK, gotStatus)
+	assert.GreaterOrEqual(t, gotDuration, 10*time.Millisecond)
+}
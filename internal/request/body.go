@@ -0,0 +1,135 @@
+package request
+
+import (
+	"fmt"
+	"io"
+)
+
+var (
+	ERROR_BODY_CLOSED = fmt.Errorf("read on closed request body")
+	ERROR_BODY_UNREAD = fmt.Errorf("request body left unread")
+)
+
+// maxDrainBytes bounds how much of an unread body Close discards to reach
+// the next request. Past it, reusing the connection is not worth the wait.
+const maxDrainBytes = 256 << 10
+
+// NoBody is the Body of a request without one.
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body decodes the body of request from its Reader as it is read.
+type body struct {
+	reader  *Reader
+	request *Request
+	// err is returned once the decoded bytes are exhausted: io.EOF at the
+	// end of the body, or the error that stopped it.
+	err    error
+	closed bool
+	// started is set by the first Read. Until then, a client expecting
+	// 100 Continue has not been asked for the body.
+	started bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ERROR_BODY_CLOSED
+	}
+
+	b.started = true
+	r := b.request
+	for len(r.decoded) == 0 && b.err == nil {
+		if r.Status == RequestStateDone {
+			b.err = io.EOF
+			break
+		}
+		if err := b.reader.advance(r); err != nil {
+			b.fail(b.reader.parseFailure(r, err))
+		}
+	}
+
+	if len(r.decoded) == 0 {
+		return 0, b.err
+	}
+	n := copy(p, r.decoded)
+	r.decoded = r.decoded[n:]
+	if len(r.decoded) == 0 {
+		r.decoded = nil
+	}
+	return n, nil
+}
+
+// fail stops the body with err. The connection is left in the middle of a
+// message, so the reader cannot parse another request from it.
+func (b *body) fail(err error) {
+	b.err = err
+	b.reader.err = err
+	b.reader.acc = nil
+}
+
+// discardable reports whether Close can discard the rest of the body and
+// leave the reader at the next request. Only the part of the body known to
+// be left counts, so a chunked body with chunks still to come may yet turn
+// out to be too long.
+func (b *body) discardable() bool {
+	r := b.request
+	switch {
+	case b.err == io.EOF:
+		return true
+	case b.err != nil:
+		return false
+	case !b.started && r.ExpectsContinue():
+		return false
+	}
+
+	left := int64(len(r.decoded))
+	switch r.Status {
+	case BodyState:
+		left += r.bodyRemaining
+	case ChunkDataState:
+		left += r.chunkRemaining
+	}
+	return left <= maxDrainBytes
+}
+
+// Close discards the rest of the body so that the next request can be
+// read. If more than maxDrainBytes are left, it gives up and returns
+// ERROR_BODY_UNREAD, and no further request can be read from the Reader;
+// when the length left is known, without reading any of it. The same goes
+// for a body never read by a client expecting 100 Continue, since the client
+// may or may not send it.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	r := b.request
+	if b.err == nil && !b.discardable() {
+		b.fail(ERROR_BODY_UNREAD)
+		return b.err
+	}
+	for drained := 0; b.err == nil; {
+		drained += len(r.decoded)
+		r.decoded = nil
+		if r.Status == RequestStateDone {
+			return nil
+		}
+		if drained > maxDrainBytes {
+			b.fail(ERROR_BODY_UNREAD)
+			break
+		}
+		if err := b.reader.advance(r); err != nil {
+			b.fail(b.reader.parseFailure(r, err))
+		}
+	}
+
+	if b.err == io.EOF {
+		return nil
+	}
+	return b.err
+}
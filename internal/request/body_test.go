@@ -0,0 +1,183 @@
+package request
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyStreaming(t *testing.T) {
+	// Test: The body is read from the source only as the caller reads it
+	source := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 20000\r\n\r\n" +
+			strings.Repeat("x", 20000),
+		numBytesPerRead: 1024,
+	}
+	reader := NewReader(source)
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Less(t, source.pos, 2048)
+
+	buf := make([]byte, 100)
+	n, err := io.ReadFull(r.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Less(t, source.pos, 2048)
+
+	rest, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Len(t, rest, 19900)
+	assert.Equal(t, len(source.data), source.pos)
+
+	// Test: A request without a body gets NoBody
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, NoBody, r.Body)
+}
+
+func TestBodyChunkedTrailers(t *testing.T) {
+	reader := NewReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"3\r\nabc\r\n4\r\ndefg\r\n0\r\nX-Sum: 7\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Empty(t, r.Trailers)
+
+	data, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdefg", string(data))
+	sum, _ := r.Trailers.Get("x-sum")
+	assert.Equal(t, []string{"7"}, sum)
+}
+
+func TestBodyClose(t *testing.T) {
+	// Test: Closing discards the rest of the body before the next request
+	reader := NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 11\r\n\r\nhello world" +
+			"GET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(r.Body, buf)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(buf)
+	assert.ErrorIs(t, err, ERROR_BODY_CLOSED)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: A body too large to drain leaves the reader unusable
+	reader = NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 1000000\r\n\r\n" +
+			strings.Repeat("x", 1000000) +
+			"GET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4096,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.Body.Close(), ERROR_BODY_UNREAD)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_BODY_UNREAD)
+
+	// Test: A malformed chunk is reported by Read
+	reader = NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"3\r\nabcdef\r\n0\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+}
+
+func TestBodyExpectContinue(t *testing.T) {
+	// Test: A body never asked for is not drained
+	reader := NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	assert.ErrorIs(t, r.Body.Close(), ERROR_BODY_UNREAD)
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_BODY_UNREAD)
+
+	// Test: Once read, it behaves like any other body
+	reader = NewReader(&chunkReader{
+		data:            "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 64,
+	})
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	data, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Test: Ignored for HTTP/1.0
+	r, err = RequestFromReader(&chunkReader{
+		data:            "POST /a HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Unknown expectations fail
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 200-ok\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ERROR_EXPECTATION_FAILED)
+	assert.Equal(t, 417, perr.Status)
+}
+
+func TestBodyCanDiscard(t *testing.T) {
+	read := func(data string) *Request {
+		r, err := NewReader(&chunkReader{data: data, numBytesPerRead: 64}).ReadRequest()
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: Short bodies and bodies read to the end can be discarded
+	r := read("POST /a HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, r.CanDiscardBody())
+	_, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.True(t, r.CanDiscardBody())
+	assert.True(t, read("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n").CanDiscardBody())
+
+	// Test: A long body cannot, and Close gives up without reading it
+	source := &chunkReader{
+		data:            "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 1000000\r\n\r\n" + strings.Repeat("x", 1000000),
+		numBytesPerRead: 4096,
+	}
+	r, err = NewReader(source).ReadRequest()
+	require.NoError(t, err)
+	assert.False(t, r.CanDiscardBody())
+	assert.ErrorIs(t, r.Body.Close(), ERROR_BODY_UNREAD)
+	assert.Less(t, source.pos, 8192)
+
+	// Test: Nor can a body the client waits to be asked for
+	r = read("POST /a HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\nhello")
+	assert.False(t, r.CanDiscardBody())
+	buf := make([]byte, 1)
+	r.Body.Read(buf)
+	assert.True(t, r.CanDiscardBody())
+}
@@ -0,0 +1,86 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ERROR_INVALID_HOST = fmt.Errorf("invalid host")
+
+// Host is the normalized host a request is addressed to.
+type Host struct {
+	// Name is the lowercased host name or IP address, without the brackets
+	// of an IPv6 literal or a trailing dot.
+	Name string
+	// Port is the port, or "" if the client did not give one.
+	Port string
+}
+
+// String returns the host in "name[:port]" form.
+func (h Host) String() string {
+	name := h.Name
+	if strings.Contains(name, ":") {
+		name = "[" + name + "]"
+	}
+	if h.Port == "" {
+		return name
+	}
+	return name + ":" + h.Port
+}
+
+// ParseHost parses a Host header value or target authority, "host[:port]".
+// A malformed value is reported as a ParseError with status 400.
+func ParseHost(value string) (Host, error) {
+	if err := checkAuthority(value, false); err != nil {
+		err.Err = ERROR_INVALID_HOST
+		return Host{}, err
+	}
+
+	name, port := value, ""
+	if strings.HasPrefix(value, "[") {
+		end := strings.IndexByte(value, ']')
+		name, port = value[1:end], strings.TrimPrefix(value[end+1:], ":")
+	} else if i := strings.LastIndexByte(value, ':'); i >= 0 {
+		name, port = value[:i], value[i+1:]
+	}
+
+	return Host{
+		Name: strings.TrimSuffix(strings.ToLower(name), "."),
+		Port: port,
+	}, nil
+}
+
+// resolveHost validates the Host header and sets r.Host. HTTP/1.1 requests
+// must carry exactly one Host field, HTTP/1.0 ones at most one. When the
+// target names an authority, it takes precedence over the Host field, which
+// is replaced with it so that both agree. An empty Host field, as sent for a
+// target without an authority, leaves r.Host empty.
+func (r *Request) resolveHost() *ParseError {
+	values, ok := r.Headers.Get("host")
+	switch {
+	case len(values) > 1:
+		return newParseError(400, r.fieldOffset("host", 1), ERROR_INVALID_HOST,
+			"request has %d Host header fields", len(values))
+	case !ok && r.RequestLine.HttpVersion != "1.0":
+		return newParseError(400, r.headerBytes, ERROR_INVALID_HOST,
+			"HTTP/1.1 requests must have a Host header field")
+	}
+
+	var host Host
+	if ok && values[0] != "" {
+		var err error
+		if host, err = ParseHost(values[0]); err != nil {
+			return newParseError(400, r.fieldOffset("host", 0), ERROR_INVALID_HOST,
+				"invalid Host header field %q", values[0])
+		}
+	}
+
+	if r.Target.Authority != "" {
+		// The authority was validated along with the target.
+		host, _ = ParseHost(r.Target.Authority)
+		r.Headers.Replace("host", r.Target.Authority)
+	}
+
+	r.Host = host
+	return nil
+}
@@ -0,0 +1,94 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Host
+	}{
+		{"localhost:42069", Host{Name: "localhost", Port: "42069"}},
+		{"WWW.Example.ORG.", Host{Name: "www.example.org"}},
+		{"192.0.2.1:80", Host{Name: "192.0.2.1", Port: "80"}},
+		{"[2001:DB8::1]:8080", Host{Name: "2001:db8::1", Port: "8080"}},
+		{"[::1]", Host{Name: "::1"}},
+		{"example.com:", Host{Name: "example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseHost(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.Equal(t, "[2001:db8::1]:8080", Host{Name: "2001:db8::1", Port: "8080"}.String())
+	assert.Equal(t, "example.com", Host{Name: "example.com"}.String())
+
+	for _, value := range []string{"", "exa mple.com", "user@example.com", "example.com:http", "[::1", "a:b:c"} {
+		_, err := ParseHost(value)
+		assert.ErrorIs(t, err, ERROR_INVALID_HOST, value)
+	}
+}
+
+func TestRequestHost(t *testing.T) {
+	read := func(data string) (*Request, error) {
+		return RequestFromReader(&chunkReader{data: data, numBytesPerRead: 5})
+	}
+
+	// Test: Host header is normalized
+	r, err := read("GET / HTTP/1.1\r\nHost: LocalHost:42069\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, Host{Name: "localhost", Port: "42069"}, r.Host)
+
+	// Test: HTTP/1.0 without Host
+	r, err = read("GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, Host{}, r.Host)
+
+	// Test: Absolute-form target overrides the Host header
+	r, err = read("GET http://api.example.test:8080/v1 HTTP/1.1\r\nHost: other.example.test\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, Host{Name: "api.example.test", Port: "8080"}, r.Host)
+	host, _ := r.Headers.Get("host")
+	assert.Equal(t, []string{"api.example.test:8080"}, host)
+
+	// Test: CONNECT takes its host from the target
+	r, err = read("CONNECT example.test:443 HTTP/1.1\r\nHost: example.test:443\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, Host{Name: "example.test", Port: "443"}, r.Host)
+
+	// Test: An empty Host header, with or without an authority in the target
+	r, err = read("GET / HTTP/1.1\r\nHost: \r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, Host{}, r.Host)
+
+	r, err = read("GET http://a/ HTTP/1.1\r\nHost:\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, Host{Name: "a"}, r.Host)
+	host, _ = r.Headers.Get("host")
+	assert.Equal(t, []string{"a"}, host)
+
+	// Test: Rejected Host headers
+	for name, data := range map[string]string{
+		"missing":                    "GET / HTTP/1.1\r\nAccept: */*\r\n\r\n",
+		"duplicate":                  "GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n",
+		"duplicate in HTTP/1.0":      "GET / HTTP/1.0\r\nHost: a.test\r\nHost: a.test\r\n\r\n",
+		"invalid":                    "GET / HTTP/1.1\r\nHost: a.test/evil\r\n\r\n",
+		"missing with absolute-form": "GET http://a.test/ HTTP/1.1\r\n\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := read(data)
+			var perr *ParseError
+			require.ErrorAs(t, err, &perr)
+			assert.ErrorIs(t, err, ERROR_INVALID_HOST)
+			assert.Equal(t, 400, perr.Status)
+		})
+	}
+}
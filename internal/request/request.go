@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
 	"strconv"
+	"strings"
 
 	"github.com/mugiwara999/httpfromtcp/internal/headers"
 )
@@ -13,10 +13,14 @@ import (
 type parseRequestState string
 
 const (
-	RequestStateInit parseRequestState = "init"
-	HeadersState     parseRequestState = "headers"
-	BodyState        parseRequestState = "body"
-	RequestStateDone parseRequestState = "done"
+	RequestStateInit  parseRequestState = "init"
+	HeadersState      parseRequestState = "headers"
+	BodyState         parseRequestState = "body"
+	ChunkSizeState    parseRequestState = "chunkSize"
+	ChunkDataState    parseRequestState = "chunkData"
+	ChunkDataEndState parseRequestState = "chunkDataEnd"
+	TrailersState     parseRequestState = "trailers"
+	RequestStateDone  parseRequestState = "done"
 )
 
 type RequestLine struct {
@@ -27,93 +31,297 @@ type RequestLine struct {
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	Status      parseRequestState
+	Headers     *headers.Headers
+	// Body streams the decoded body from the connection as it is read. It
+	// is NoBody for requests without one.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body once Body has
+	// returned io.EOF.
+	Trailers *headers.Headers
+	Status   parseRequestState
+	// Target is the parsed form of RequestLine.RequestTarget.
+	Target Target
+	// Host is the host the request is addressed to, taken from the target
+	// if it names one and from the Host header otherwise.
+	Host Host
+
+	// Params holds the path parameters captured by the route that matched
+	// the request, if any.
+	Params map[string]string
+
+	limits        Limits
+	headerOptions headers.ParseOptions
+	headerBytes   int
+	// fieldOffsets holds the offsets of the header field lines from the
+	// start of the header section, by lowercased name, while it is parsed.
+	fieldOffsets   map[string][]int
+	offset         int
+	bodyRemaining  int64
+	chunkRemaining int64
+	// decoded holds body bytes parsed but not yet returned by Body, and
+	// bodyBytes counts all the body bytes parsed so far.
+	decoded   []byte
+	bodyBytes int64
+	// body is the Body returned by Reader.ReadRequest, before any wrapping.
+	body *body
+}
+
+// Limits bounds the size of the parts of a request. A zero field means no
+// limit.
+type Limits struct {
+	// MaxRequestLineBytes bounds the request line, excluding its CRLF.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes bounds the header section, and separately the trailer
+	// section of a chunked body.
+	MaxHeaderBytes int
+	// MaxHeaderCount bounds the number of header (or trailer) field lines.
+	MaxHeaderCount int
+	// MaxBodyBytes bounds the decoded body.
+	MaxBodyBytes int64
+}
+
+// DefaultLimits are the limits applied by the server unless configured
+// otherwise.
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      64 << 10,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        10 << 20,
 }
 
 var (
 	ERROR_MALFORMED_REQUEST_LINE   = fmt.Errorf("malformed request line")
 	ERROR_UNSUPPORTED_HTTP_VERSION = fmt.Errorf("unsupported HTTP version")
 	ERROR_INCOMPLETE_REQUEST       = fmt.Errorf("incomplete request")
+	ERROR_MALFORMED_CHUNK          = fmt.Errorf("malformed chunked body")
+	ERROR_REQUEST_LINE_TOO_LONG    = fmt.Errorf("request line too long")
+	ERROR_HEADERS_TOO_LARGE        = fmt.Errorf("header section too large")
+	ERROR_TOO_MANY_HEADERS         = fmt.Errorf("too many header fields")
+	ERROR_BODY_TOO_LARGE           = fmt.Errorf("body too large")
+	ERROR_UNSUPPORTED_CODING       = fmt.Errorf("unsupported transfer coding")
+	ERROR_LENGTH_REQUIRED          = fmt.Errorf("length required")
+	ERROR_INVALID_CONTENT_LENGTH   = fmt.Errorf("invalid content-length")
+	ERROR_CONFLICTING_FRAMING      = fmt.Errorf("conflicting message framing")
+	ERROR_EXPECTATION_FAILED       = fmt.Errorf("expectation failed")
 )
 
+// ParseError is returned for a request that cannot be parsed. Its Offset is
+// counted from the first byte of the request, and Status is the response
+// code the request deserves. An error found in the header section as a
+// whole, such as conflicting framing, points at the start of the field line
+// at fault.
+type ParseError = headers.ParseError
+
+func newParseError(status, offset int, err error, reason string, args ...any) *ParseError {
+	return &ParseError{
+		Status: status,
+		Offset: offset,
+		Reason: fmt.Sprintf(reason, args...),
+		Err:    err,
+	}
+}
+
+// maxChunkSizeLineBytes bounds a chunk-size line including its extensions.
+const maxChunkSizeLineBytes = 4096
+
 const SEPARATOR = "\r\n"
 
 func (r *Request) parse(data []byte) (int, error) {
-	totalConsumed := 0
+	read := 0
 
+	for r.Status != RequestStateDone {
+		inHeaders := r.Status == HeadersState
+		n, err := r.parseSingle(data[read:])
+		if err != nil {
+			if perr, ok := err.(*ParseError); ok {
+				perr.Offset += r.offset + read
+			}
+			return read, err
+		}
+		if n == 0 {
+			break // need more data
+		}
+		read += n
+		if inHeaders && r.Status != HeadersState {
+			// The body is parsed as it is read, so that its errors are
+			// reported by Body.
+			break
+		}
+	}
+
+	r.offset += read
+	return read, nil
+}
+
+func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.Status {
 
 	case RequestStateInit:
-		rl, n, err := parseRequestLine(data)
-		if err != nil {
-			return 0, err
+		bareLF := r.headerOptions.AllowBareLF
+		idx, sepLen := lineEnd(data, bareLF)
+		if idx == 0 && bareLF {
+			// Empty lines before the request line, such as the CRLF some
+			// clients send after a POST body, are skipped.
+			return sepLen, nil
+		}
+
+		var rl *RequestLine
+		lineLength := len(data)
+		if idx != -1 {
+			var err error
+			if rl, err = parseRequestLine(data[:idx]); err != nil {
+				return 0, err
+			}
+			lineLength = idx
+		}
+		if max := r.limits.MaxRequestLineBytes; max > 0 && lineLength > max {
+			return 0, newParseError(414, max, ERROR_REQUEST_LINE_TOO_LONG, "request line exceeds %d bytes", max)
 		}
+
 		if rl == nil {
-			return 0, nil // need more data
+			return 0, nil
+		}
+
+		target, err := ParseTarget(rl.Method, rl.RequestTarget)
+		if err != nil {
+			if perr, ok := err.(*ParseError); ok {
+				perr.Offset += len(rl.Method) + 1
+			}
+			return 0, err
 		}
 
 		r.RequestLine = *rl
+		r.Target = target
 		r.Status = HeadersState
-		totalConsumed += n
-
-		i, err := r.parse(data[n:])
-
-		return i + totalConsumed, err
+		return idx + sepLen, nil
 
 	case HeadersState:
 		if r.Headers == nil {
 			r.Headers = headers.NewHeaders()
 		}
 
-		n, done, err := r.Headers.Parse(data)
+		start := r.headerBytes
+		opts := r.headerOptions
+		opts.OnField = func(name string, offset int) {
+			key := strings.ToLower(name)
+			if r.fieldOffsets == nil {
+				r.fieldOffsets = map[string][]int{}
+			}
+			r.fieldOffsets[key] = append(r.fieldOffsets[key], start+offset)
+		}
+
+		n, done, err := r.Headers.ParseWith(data, opts)
 		if err != nil {
-			return totalConsumed, err
+			return 0, err
 		}
 
-		totalConsumed += n
+		r.headerBytes += n
+		if err := r.checkFieldSection(r.Headers, done, len(data)-n); err != nil {
+			return 0, err
+		}
 
 		if done {
+			length, err := r.checkHeaderSection()
+			if err != nil {
+				// The section started before data by the bytes parsed in
+				// earlier calls.
+				err.Offset -= start
+				return 0, err
+			}
+
 			r.Status = RequestStateDone
-			if cl, ok := r.Headers["content-length"]; ok && len(cl) > 0 {
+			if r.isChunked() {
+				r.Status = ChunkSizeState
+			} else if length > 0 {
+				r.bodyRemaining = length
+				r.Status = BodyState
+			}
+			r.headerBytes = 0
+			r.fieldOffsets = nil
+		}
 
-				l, _ := strconv.Atoi(cl[0])
+		return n, nil
 
-				if l > 0 {
+	case BodyState:
+		toConsume := int(min(int64(len(data)), r.bodyRemaining))
+		r.appendBody(data[:toConsume])
+		r.bodyRemaining -= int64(toConsume)
+
+		if r.bodyRemaining == 0 {
+			r.Status = RequestStateDone
+		}
 
-					r.Status = BodyState
-					// Continue parsing body if we have more data after headers
-					remainingData := data[n:]
-					if len(remainingData) > 0 {
-						i, err := r.parse(remainingData)
-						return i + totalConsumed, err
-					}
-				}
+		return toConsume, nil
 
+	case ChunkSizeState:
+		idx, sepLen := lineEnd(data, r.headerOptions.AllowBareLF)
+		if idx == -1 {
+			if len(data) > maxChunkSizeLineBytes {
+				return 0, newParseError(400, 0, ERROR_MALFORMED_CHUNK, "chunk size line exceeds %d bytes", maxChunkSizeLineBytes)
 			}
+			return 0, nil
+		}
+
+		size, err := parseChunkSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		if max := r.limits.MaxBodyBytes; max > 0 && r.bodyBytes+size > max {
+			return 0, newParseError(413, 0, ERROR_BODY_TOO_LARGE, "chunked body exceeds the limit of %d bytes", max)
 		}
 
-		return totalConsumed, nil
+		if size == 0 {
+			r.Status = TrailersState
+		} else {
+			r.chunkRemaining = size
+			r.Status = ChunkDataState
+		}
+		return idx + sepLen, nil
 
-	case BodyState:
+	case ChunkDataState:
+		toConsume := int(min(int64(len(data)), r.chunkRemaining))
+		r.appendBody(data[:toConsume])
+		r.chunkRemaining -= int64(toConsume)
 
-		n := r.Headers["content-length"]
-		l, _ := strconv.Atoi(n[0])
+		if r.chunkRemaining == 0 {
+			r.Status = ChunkDataEndState
+		}
+		return toConsume, nil
 
-		if len(r.Body) > l {
-			return len(data), ERROR_INCOMPLETE_REQUEST
+	case ChunkDataEndState:
+		if r.headerOptions.AllowBareLF && len(data) > 0 && data[0] == '\n' {
+			r.Status = ChunkSizeState
+			return 1, nil
+		}
+		if len(data) < len(SEPARATOR) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(SEPARATOR)) {
+			return 0, newParseError(400, 0, ERROR_MALFORMED_CHUNK, "chunk data is longer than its declared size")
 		}
 
-		toConsume := min(len(data), l-len(r.Body))
-		r.Body = append(r.Body, data[:toConsume]...)
+		r.Status = ChunkSizeState
+		return len(SEPARATOR), nil
 
-		if len(r.Body) == l {
-			r.Status = RequestStateDone
-			return toConsume, nil
+	case TrailersState:
+		if r.Trailers == nil {
+			r.Trailers = headers.NewHeaders()
 		}
 
-		return toConsume, nil
+		n, done, err := r.Trailers.ParseWith(data, r.headerOptions)
+		if err != nil {
+			return 0, err
+		}
+
+		r.headerBytes += n
+		if err := r.checkFieldSection(r.Trailers, done, len(data)-n); err != nil {
+			return 0, err
+		}
+
+		if done {
+			r.Status = RequestStateDone
+		}
+		return n, nil
 
 	case RequestStateDone:
 		return 0, nil
@@ -122,21 +330,238 @@ func (r *Request) parse(data []byte) (int, error) {
 	return 0, nil
 }
 
-func parseRequestLine(b []byte) (*RequestLine, int, error) {
-	idx := bytes.Index(b, []byte(SEPARATOR))
-	if idx == -1 {
-		return nil, 0, nil
+func (r *Request) appendBody(p []byte) {
+	r.decoded = append(r.decoded, p...)
+	r.bodyBytes += int64(len(p))
+}
+
+// checkFieldSection enforces the header limits on the header or trailer
+// section being parsed into h. While the section is incomplete, the pending
+// bytes of its unfinished line count towards its size.
+func (r *Request) checkFieldSection(h *headers.Headers, done bool, pending int) error {
+	size := r.headerBytes
+	if !done {
+		size += pending
+	}
+	if max := r.limits.MaxHeaderBytes; max > 0 && size > max {
+		return newParseError(431, 0, ERROR_HEADERS_TOO_LARGE, "header section exceeds %d bytes", max)
+	}
+
+	if max := r.limits.MaxHeaderCount; max > 0 {
+		count := 0
+		h.ForEach(func(_ string, v []string) {
+			count += len(v)
+		})
+		if count > max {
+			return newParseError(431, 0, ERROR_TOO_MANY_HEADERS, "more than %d header fields", max)
+		}
+	}
+	return nil
+}
+
+// checkExpect rejects expectations other than 100-continue, the only one
+// defined. HTTP/1.0 requests predate Expect, which is ignored for them.
+func (r *Request) checkExpect() *ParseError {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return nil
+	}
+	values, _ := r.Headers.Get("expect")
+	for i, v := range values {
+		for _, expectation := range headers.SplitList(v) {
+			if !strings.EqualFold(expectation, "100-continue") {
+				return newParseError(417, r.fieldOffset("expect", i), ERROR_EXPECTATION_FAILED,
+					"expectation %q is not supported", expectation)
+			}
+		}
+	}
+	return nil
+}
+
+// CanDiscardBody reports whether the connection can still be used for
+// another request once the handler is done with the body. It is false if a
+// client expecting 100 Continue was never asked for the body, if reading it
+// failed, or if more of it is left than Body.Close is willing to discard.
+func (r *Request) CanDiscardBody() bool {
+	return r.body == nil || r.body.discardable()
+}
+
+// ExpectsContinue reports whether the client waits for a 100 Continue
+// interim response before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.RequestLine.HttpVersion != "1.0" && len(r.Headers.Elements("expect")) > 0
+}
+
+// checkHeaderSection validates a complete header section and returns the
+// length of the body declared by Content-Length, or 0 if there is none. The
+// offset of an error is that of the field line at fault, counted from the
+// start of the section, or the end of the section if a field is missing.
+func (r *Request) checkHeaderSection() (int64, *ParseError) {
+	if _, ok := r.Headers.Get("transfer-encoding"); ok && r.RequestLine.HttpVersion == "1.0" {
+		return 0, newParseError(411, r.fieldOffset("transfer-encoding", 0), ERROR_LENGTH_REQUIRED,
+			"HTTP/1.0 requests cannot use Transfer-Encoding; send a Content-Length instead")
+	}
+	if err := r.resolveHost(); err != nil {
+		return 0, err
+	}
+	if err := r.checkExpect(); err != nil {
+		return 0, err
+	}
+	if err := r.checkFraming(); err != nil {
+		return 0, err
+	}
+	if r.isChunked() {
+		return 0, nil
+	}
+
+	length, err := r.contentLength()
+	if err != nil {
+		return 0, err
+	}
+	if max := r.limits.MaxBodyBytes; max > 0 && length > max {
+		return 0, newParseError(413, r.fieldOffset("content-length", 0), ERROR_BODY_TOO_LARGE,
+			"body of %d bytes exceeds the limit of %d bytes", length, max)
+	}
+	return length, nil
+}
+
+// fieldOffset returns the offset of the i-th header field line named name,
+// counted from the start of the header section, or the end of the section
+// if there are not that many.
+func (r *Request) fieldOffset(name string, i int) int {
+	if offsets := r.fieldOffsets[name]; i < len(offsets) {
+		return offsets[i]
+	}
+	return r.headerBytes
+}
+
+// checkFraming rejects requests whose body framing could be read
+// differently by another parser on the path: a Transfer-Encoding alongside a
+// Content-Length, or transfer codings other than a single, final chunked.
+func (r *Request) checkFraming() *ParseError {
+	values, ok := r.Headers.Get("transfer-encoding")
+	if !ok {
+		return nil
+	}
+	if _, ok := r.Headers.Get("content-length"); ok {
+		// Point at whichever of the two fields came second.
+		offset := max(r.fieldOffset("transfer-encoding", 0), r.fieldOffset("content-length", 0))
+		return newParseError(400, offset, ERROR_CONFLICTING_FRAMING,
+			"request has both Transfer-Encoding and Content-Length")
+	}
+
+	for i, v := range values {
+		for _, coding := range headers.SplitList(v) {
+			if !strings.EqualFold(coding, "chunked") {
+				return newParseError(501, r.fieldOffset("transfer-encoding", i), ERROR_UNSUPPORTED_CODING,
+					"transfer coding %q is not supported", coding)
+			}
+		}
+	}
+	codings := 0
+	for i, v := range values {
+		codings += len(headers.SplitList(v))
+		if codings > 1 {
+			return newParseError(400, r.fieldOffset("transfer-encoding", i), ERROR_CONFLICTING_FRAMING,
+				"chunked transfer coding applied more than once")
+		}
 	}
+	if codings == 0 {
+		return newParseError(400, r.fieldOffset("transfer-encoding", 0), ERROR_CONFLICTING_FRAMING,
+			"Transfer-Encoding names no transfer coding")
+	}
+	return nil
+}
+
+// contentLength parses the Content-Length fields of a request, returning 0
+// if there are none. Repeated fields, or a comma-separated list, are only
+// accepted when every element is the same decimal number.
+func (r *Request) contentLength() (int64, *ParseError) {
+	values, _ := r.Headers.Get("content-length")
+	length := int64(-1)
+	for i, v := range values {
+		for element := range strings.SplitSeq(v, ",") {
+			element = strings.Trim(element, " \t")
+			if element == "" || len(element) > 18 || strings.TrimLeft(element, "0123456789") != "" {
+				return 0, newParseError(400, r.fieldOffset("content-length", i), ERROR_INVALID_CONTENT_LENGTH,
+					"invalid Content-Length %q", v)
+			}
+
+			l, _ := strconv.ParseInt(element, 10, 64)
+			if length >= 0 && l != length {
+				return 0, newParseError(400, r.fieldOffset("content-length", i), ERROR_INVALID_CONTENT_LENGTH,
+					"conflicting Content-Length values %d and %d", length, l)
+			}
+			length = l
+		}
+	}
+	return max(length, 0), nil
+}
+
+// isChunked reports whether the body is framed with the chunked transfer
+// coding, which must be the last one applied.
+func (r *Request) isChunked() bool {
+	codings := r.Headers.Elements("transfer-encoding")
+	return len(codings) > 0 && strings.EqualFold(codings[len(codings)-1], "chunked")
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions:
+//
+//	chunk-size [ ";" ext-name [ "=" ext-val ] ]...
+func parseChunkSize(line []byte) (int64, error) {
+	sizePart, extensions, hasExtensions := bytes.Cut(line, []byte(";"))
 
-	startLine := b[:idx]
+	sizePart = bytes.TrimRight(sizePart, " \t")
+	if len(sizePart) == 0 || len(sizePart) > 15 {
+		return 0, newParseError(400, 0, ERROR_MALFORMED_CHUNK, "invalid chunk size %q", sizePart)
+	}
+	for _, b := range sizePart {
+		if (b < '0' || b > '9') && (b < 'a' || b > 'f') && (b < 'A' || b > 'F') {
+			return 0, newParseError(400, 0, ERROR_MALFORMED_CHUNK, "invalid chunk size %q", sizePart)
+		}
+	}
+
+	if hasExtensions {
+		for ext := range bytes.SplitSeq(extensions, []byte(";")) {
+			name, _, _ := bytes.Cut(ext, []byte("="))
+			if len(bytes.Trim(name, " \t")) == 0 {
+				return 0, newParseError(400, 0, ERROR_MALFORMED_CHUNK, "chunk extension has no name")
+			}
+		}
+	}
+
+	return strconv.ParseInt(string(sizePart), 16, 64)
+}
+
+// lineEnd returns the length of the line at the start of data and of its
+// terminator, or -1 if data holds no complete line. A lone LF only ends a
+// line if bareLF is set.
+func lineEnd(data []byte, bareLF bool) (int, int) {
+	if !bareLF {
+		return bytes.Index(data, []byte(SEPARATOR)), len(SEPARATOR)
+	}
+	i := bytes.IndexByte(data, '\n')
+	if i > 0 && data[i-1] == '\r' {
+		return i - 1, len(SEPARATOR)
+	}
+	return i, 1
+}
+
+// parseRequestLine parses a request line without its terminator.
+func parseRequestLine(startLine []byte) (*RequestLine, error) {
 	parts := bytes.Split(startLine, []byte(" "))
 	if len(parts) != 3 {
-		return nil, 0, ERROR_MALFORMED_REQUEST_LINE
+		return nil, newParseError(400, 0, ERROR_MALFORMED_REQUEST_LINE,
+			"request line must be a method, a target and a version separated by single spaces")
 	}
 
 	httpv := bytes.Split(parts[2], []byte("/"))
-	if len(httpv) != 2 || !bytes.Equal(httpv[0], []byte("HTTP")) || !bytes.Equal(httpv[1], []byte("1.1")) {
-		return nil, 0, ERROR_UNSUPPORTED_HTTP_VERSION
+	if len(httpv) != 2 || !bytes.Equal(httpv[0], []byte("HTTP")) || !isVersionNumber(httpv[1]) {
+		return nil, newParseError(400, len(parts[0])+len(parts[1])+2, ERROR_MALFORMED_REQUEST_LINE,
+			"invalid HTTP version %q", parts[2])
+	}
+	if !bytes.Equal(httpv[1], []byte("1.1")) && !bytes.Equal(httpv[1], []byte("1.0")) {
+		return nil, newParseError(505, len(parts[0])+len(parts[1])+2, ERROR_UNSUPPORTED_HTTP_VERSION,
+			"HTTP version %s is not supported", httpv[1])
 	}
 
 	rl := &RequestLine{
@@ -145,52 +570,203 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 		HttpVersion:   string(httpv[1]),
 	}
 
-	n := idx + len(SEPARATOR)
-	return rl, n, nil
+	return rl, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	r := &Request{
-		Status:  RequestStateInit,
-		Headers: headers.NewHeaders(),
-		Body:    []byte{},
+type Reader struct {
+	// OnHeaders, if set, is called once the header section of each request
+	// has been parsed, before its body is read.
+	OnHeaders func(*Request)
+	// Limits bounds the size of each request read.
+	Limits Limits
+	// ObsFold decides whether header and trailer fields folded over
+	// several lines are rejected, the default, or unfolded.
+	ObsFold headers.ObsFoldPolicy
+	// AllowBareLF accepts a lone LF wherever a CRLF ends a line, and skips
+	// empty lines before a request line. Requests must otherwise use CRLF.
+	AllowBareLF bool
+
+	reader io.Reader
+	buf    []byte
+	acc    []byte
+	err    error
+	// body is the body of the last request returned, which must be read or
+	// closed before the next request can be parsed.
+	body *body
+}
+
+// NewReader returns a Reader that parses consecutive requests from reader,
+// keeping any bytes read past the end of one request for the next.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, 4096),
 	}
+}
 
-	buf := make([]byte, 4096)
-	acc := []byte{}
+// Buffered returns the number of bytes already read from the underlying
+// reader but not yet consumed by a request.
+func (rr *Reader) Buffered() int {
+	return len(rr.acc)
+}
 
-	for r.Status != RequestStateDone {
-		if len(acc) > 0 {
-			consumed, parseErr := r.parse(acc)
-			if parseErr != nil {
-				return nil, parseErr
-			}
-			if consumed > 0 {
-				acc = acc[consumed:]
-			}
+// WaitForRequest blocks until the first bytes of the next request are
+// available. Like ReadRequest, it returns io.EOF if the underlying reader
+// ends cleanly before then, and it closes the body of the previous request
+// first.
+func (rr *Reader) WaitForRequest() error {
+	if err := rr.closeBody(); err != nil {
+		return err
+	}
+	for len(rr.acc) == 0 {
+		if rr.err != nil {
+			return rr.err
 		}
+		rr.fill()
+	}
+	return nil
+}
 
-		if r.Status == RequestStateDone {
-			break
-		}
+func (rr *Reader) fill() {
+	n, err := rr.reader.Read(rr.buf)
+	if n > 0 {
+		rr.acc = append(rr.acc, rr.buf[:n]...)
+	}
+	if err != nil {
+		rr.err = err
+	}
+}
 
-		n, err := reader.Read(buf)
-		if n > 0 {
-			acc = append(acc, buf[:n]...)
-		}
+// closeBody closes the body of the previous request, discarding what is
+// left of it.
+func (rr *Reader) closeBody() error {
+	if rr.body == nil {
+		return nil
+	}
+	err := rr.body.Close()
+	rr.body = nil
+	return err
+}
 
-		if err == io.EOF {
-			if r.Status != RequestStateDone {
-				log.Println(r.Status)
-				return nil, ERROR_INCOMPLETE_REQUEST
+// ReadRequest parses the request line and headers of the next request. Its
+// body is read from the underlying reader through Request.Body, which must
+// not be used once ReadRequest or WaitForRequest is called again: any part
+// of it left unread is then discarded.
+//
+// ReadRequest returns io.EOF if the underlying reader ends cleanly before
+// any byte of a new request has arrived.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	if err := rr.closeBody(); err != nil {
+		return nil, err
+	}
+
+	r := &Request{
+		Status:  RequestStateInit,
+		Headers: headers.NewHeaders(),
+		limits:  rr.Limits,
+		headerOptions: headers.ParseOptions{
+			ObsFold:     rr.ObsFold,
+			AllowBareLF: rr.AllowBareLF,
+		},
+	}
+
+	for r.Status == RequestStateInit || r.Status == HeadersState {
+		if err := rr.advance(r); err != nil {
+			if err == io.EOF && r.Status == RequestStateInit && len(rr.acc) == 0 {
+				return nil, io.EOF
 			}
-			break
+			return nil, rr.parseFailure(r, err)
 		}
+	}
 
+	if rr.OnHeaders != nil {
+		rr.OnHeaders(r)
+	}
+
+	r.Body = NoBody
+	if r.Status != RequestStateDone {
+		rr.body = &body{reader: rr, request: r}
+		r.Body = rr.body
+		r.body = rr.body
+	}
+	return r, nil
+}
+
+// advance parses the bytes buffered for r, reading more from the
+// underlying reader if they are not enough to make progress. It returns the
+// error of the underlying reader once it has nothing more to give.
+func (rr *Reader) advance(r *Request) error {
+	if len(rr.acc) > 0 {
+		consumed, err := r.parse(rr.acc)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		if consumed > 0 {
+			rr.acc = append(rr.acc[:0], rr.acc[consumed:]...)
+			return nil
+		}
+	}
+
+	if rr.err != nil {
+		return rr.err
+	}
+	rr.fill()
+	return nil
+}
+
+// parseFailure turns an error met while parsing r into the error to report,
+// with the end of the stream meaning the request is incomplete.
+func (rr *Reader) parseFailure(r *Request, err error) error {
+	if err == io.EOF {
+		return newParseError(400, r.offset+len(rr.acc), ERROR_INCOMPLETE_REQUEST,
+			"connection closed before the request was complete")
+	}
+	return err
+}
+
+// isVersionNumber reports whether b has the form DIGIT "." DIGIT.
+func isVersionNumber(b []byte) bool {
+	return len(b) == 3 && b[0] >= '0' && b[0] <= '9' && b[1] == '.' && b[2] >= '0' && b[2] <= '9'
+}
+
+// RequestFromReader reads a single request from reader, including the whole
+// of its body, which is kept in memory.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	r, err := NewReader(reader).ReadRequest()
+	if err == io.EOF {
+		return nil, newParseError(400, 0, ERROR_INCOMPLETE_REQUEST, "no request received")
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
 	return r, nil
 }
+
+// Param returns the path parameter captured under name, or "" if there is
+// none.
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// KeepAlive reports whether the client is willing to send further requests
+// on the same connection once this one has been answered. HTTP/1.1
+// connections persist unless the client sends "Connection: close", while
+// HTTP/1.0 ones only do with "Connection: keep-alive".
+func (r *Request) KeepAlive() bool {
+	keepAlive := r.RequestLine.HttpVersion != "1.0"
+	for _, token := range r.Headers.Elements("connection") {
+		if strings.EqualFold(token, "close") {
+			return false
+		}
+		if strings.EqualFold(token, "keep-alive") {
+			keepAlive = true
+		}
+	}
+	return keepAlive
+}
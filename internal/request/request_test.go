@@ -1,9 +1,11 @@
 package request
 
 import (
+	"bytes"
 	"io"
 	"testing"
 
+	"github.com/mugiwara999/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -27,6 +29,12 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func readBody(t *testing.T, r *Request) string {
+	data, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
 func TestRequestLineParse(t *testing.T) {
 	// Test: Good GET Request line
 	reader := &chunkReader{
@@ -66,7 +74,7 @@ func TestBodyParsing(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Empty Body, 0 reported content length (valid)
 	reader = &chunkReader{
@@ -79,7 +87,7 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Body))
+	assert.Equal(t, 0, len(readBody(t, r)))
 
 	// Test: Empty Body, no reported content length (valid)
 	reader = &chunkReader{
@@ -91,7 +99,7 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Body))
+	assert.Equal(t, 0, len(readBody(t, r)))
 
 	// Test: Body shorter than reported content length (should error)
 	reader = &chunkReader{
@@ -116,5 +124,497 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body)) // body ignored by design
+	assert.Equal(t, "", readBody(t, r)) // body ignored by design
+}
+
+func TestReaderConsecutiveRequests(t *testing.T) {
+	// Test: Two requests read back to back keep the bytes between them
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Connection: close\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.True(t, r.KeepAlive())
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Clean end of stream between requests
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: End of stream in the middle of a request
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n",
+		numBytesPerRead: 5,
+	})
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, ERROR_INCOMPLETE_REQUEST)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Connection tokens are matched case-insensitively within a list
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: Upgrade, Close\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: keep-alive is the default
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: keep-alive\r\n\r\n",
+		numBytesPerRead: 8,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+}
+
+func TestReaderPipelinedRequests(t *testing.T) {
+	// Test: Several requests delivered in a single read are parsed in order
+	data := "GET /a HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /c HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+	reader := NewReader(&chunkReader{
+		data:            data,
+		numBytesPerRead: len(data),
+	})
+
+	for _, target := range []string{"/a", "/b", "/c"} {
+		r, err := reader.ReadRequest()
+		require.NoError(t, err)
+		assert.Equal(t, target, r.RequestLine.RequestTarget)
+		if target == "/b" {
+			assert.Equal(t, "abc", readBody(t, r))
+		}
+	}
+
+	_, err := reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestChunkedBodyParsing(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=value\r\n world!\r\n" +
+			"A ; last\r\n from TCP\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world! from TCP\n", readBody(t, r))
+	checksum, ok := r.Trailers.Get("x-checksum")
+	require.True(t, ok)
+	assert.Equal(t, []string{"abc123"}, checksum)
+
+	// Test: Chunked body without trailers
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", readBody(t, r))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Invalid chunk size (should error)
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0x3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+
+	// Test: Chunk data longer than its size (should error)
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"2\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+
+	// Test: Missing terminating chunk (should error)
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReader(reader)
+	assert.ErrorIs(t, err, ERROR_INCOMPLETE_REQUEST)
+}
+
+func TestReaderOnHeaders(t *testing.T) {
+	// Test: The hook runs once per request, after the headers are parsed
+	reader := NewReader(&chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	seen := []string{}
+	reader.OnHeaders = func(r *Request) {
+		host, _ := r.Headers.Get("host")
+		seen = append(seen, r.RequestLine.RequestTarget+" "+host[0])
+	}
+
+	for range 2 {
+		_, err := reader.ReadRequest()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"/a localhost:42069", "/b localhost:42069"}, seen)
+}
+
+func readWithLimits(data string, limits Limits) (*Request, error) {
+	reader := NewReader(&chunkReader{
+		data:            data,
+		numBytesPerRead: 3,
+	})
+	reader.Limits = limits
+	r, err := reader.ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return r, nil
+}
+
+func TestLimits(t *testing.T) {
+	request := "POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 13\r\n" +
+		"\r\n" +
+		"hello world!\n"
+
+	// Test: Request within every limit
+	r, err := readWithLimits(request, Limits{
+		MaxRequestLineBytes: len("POST /submit HTTP/1.1"),
+		MaxHeaderBytes:      len("Host: localhost:42069\r\nContent-Length: 13\r\n\r\n"),
+		MaxHeaderCount:      2,
+		MaxBodyBytes:        13,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", readBody(t, r))
+
+	// Test: Request line too long, with or without its CRLF received
+	_, err = readWithLimits(request, Limits{MaxRequestLineBytes: 20})
+	assert.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+	_, err = readWithLimits("GET /"+string(make([]byte, 100)), Limits{MaxRequestLineBytes: 20})
+	assert.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: Header section too large
+	_, err = readWithLimits(request, Limits{MaxHeaderBytes: 30})
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// Test: Too many header fields
+	_, err = readWithLimits(request, Limits{MaxHeaderCount: 1})
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_HEADERS)
+
+	// Test: Content-Length over the body limit
+	_, err = readWithLimits(request, Limits{MaxBodyBytes: 12})
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+
+	// Test: Chunked body growing over the body limit
+	_, err = readWithLimits("POST /upload HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"8\r\n12345678\r\n"+
+		"8\r\n12345678\r\n"+
+		"0\r\n\r\n", Limits{MaxBodyBytes: 10})
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		status int
+		offset int
+		err    error
+	}{
+		{
+			name:   "unsupported version",
+			data:   "GET / HTTP/2.0\r\nHost: localhost:42069\r\n\r\n",
+			status: 505,
+			offset: 6,
+			err:    ERROR_UNSUPPORTED_HTTP_VERSION,
+		},
+		{
+			name:   "not an HTTP version",
+			data:   "GET / FTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			status: 400,
+			offset: 6,
+			err:    ERROR_MALFORMED_REQUEST_LINE,
+		},
+		{
+			name:   "missing target",
+			data:   "GET HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+			status: 400,
+			offset: 0,
+			err:    ERROR_MALFORMED_REQUEST_LINE,
+		},
+		{
+			name:   "invalid header name",
+			data:   "GET / HTTP/1.1\r\nHost: localhost:42069\r\nBad[Name]: x\r\n\r\n",
+			status: 400,
+			offset: 39,
+			err:    headers.ErrorInvalidFieldName,
+		},
+		{
+			name:   "unsupported transfer coding",
+			data:   "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: gzip, chunked\r\n\r\n",
+			status: 501,
+			offset: 40,
+			err:    ERROR_UNSUPPORTED_CODING,
+		},
+		{
+			name:   "chunked applied twice",
+			data:   "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n",
+			status: 400,
+			offset: 68,
+			err:    ERROR_CONFLICTING_FRAMING,
+		},
+		{
+			name:   "both framings",
+			data:   "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n",
+			status: 400,
+			offset: 59,
+			err:    ERROR_CONFLICTING_FRAMING,
+		},
+		{
+			name:   "HTTP/1.0 with transfer-encoding",
+			data:   "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n",
+			status: 411,
+			offset: 17,
+			err:    ERROR_LENGTH_REQUIRED,
+		},
+		{
+			name:   "signed content-length",
+			data:   "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: +5\r\n\r\nhello",
+			status: 400,
+			offset: 40,
+			err:    ERROR_INVALID_CONTENT_LENGTH,
+		},
+		{
+			name:   "conflicting content-length",
+			data:   "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello",
+			status: 400,
+			offset: 59,
+			err:    ERROR_INVALID_CONTENT_LENGTH,
+		},
+		{
+			name:   "duplicate host",
+			data:   "GET / HTTP/1.1\r\nHost: a.test\r\nAccept: */*\r\nHost: b.test\r\n\r\n",
+			status: 400,
+			offset: 43,
+			err:    ERROR_INVALID_HOST,
+		},
+		{
+			name:   "invalid host",
+			data:   "GET / HTTP/1.1\r\nAccept: */*\r\nHost: a b\r\n\r\n",
+			status: 400,
+			offset: 29,
+			err:    ERROR_INVALID_HOST,
+		},
+		{
+			name:   "missing host",
+			data:   "GET / HTTP/1.1\r\nAccept: */*\r\n\r\n",
+			status: 400,
+			offset: 31,
+			err:    ERROR_INVALID_HOST,
+		},
+		{
+			name:   "unsupported expectation",
+			data:   "PUT / HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-continue\r\nExpect: later\r\nContent-Length: 5\r\n\r\n",
+			status: 417,
+			offset: 61,
+			err:    ERROR_EXPECTATION_FAILED,
+		},
+		{
+			name:   "invalid chunk size",
+			data:   "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+			status: 400,
+			offset: 70,
+			err:    ERROR_MALFORMED_CHUNK,
+		},
+		{
+			name:   "incomplete",
+			data:   "GET / HTTP/1.1\r\nHost: local",
+			status: 400,
+			offset: 27,
+			err:    ERROR_INCOMPLETE_REQUEST,
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := RequestFromReader(&chunkReader{data: tt.data, numBytesPerRead: 4})
+		require.Error(t, err, tt.name)
+
+		var perr *ParseError
+		require.ErrorAs(t, err, &perr, tt.name)
+		assert.ErrorIs(t, err, tt.err, tt.name)
+		assert.Equal(t, tt.status, perr.Status, tt.name)
+		assert.Equal(t, tt.offset, perr.Offset, tt.name)
+		assert.NotEmpty(t, perr.Reason, tt.name)
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 request without Host, closing by default
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET /status HTTP/1.0\r\nUser-Agent: health-check\r\n\r\n",
+		numBytesPerRead: 6,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 opting in to keep-alive
+	r, err = RequestFromReader(&chunkReader{
+		data:            "GET /status HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n",
+		numBytesPerRead: 6,
+	})
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 with a chunked body (should error)
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST /upload HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 6,
+	})
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ERROR_LENGTH_REQUIRED)
+	assert.Equal(t, 411, perr.Status)
+}
+
+func TestFraming(t *testing.T) {
+	// Test: Identical repeated Content-Length values are accepted
+	r, err := RequestFromReader(&chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\nContent-Length: 5, 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	tests := []struct {
+		name   string
+		fields string
+		status int
+		err    error
+	}{
+		{"differing content-length", "Content-Length: 5\r\nContent-Length: 6\r\n", 400, ERROR_INVALID_CONTENT_LENGTH},
+		{"differing content-length list", "Content-Length: 5, 6\r\n", 400, ERROR_INVALID_CONTENT_LENGTH},
+		{"negative content-length", "Content-Length: -1\r\n", 400, ERROR_INVALID_CONTENT_LENGTH},
+		{"signed content-length", "Content-Length: +5\r\n", 400, ERROR_INVALID_CONTENT_LENGTH},
+		{"non-numeric content-length", "Content-Length: five\r\n", 400, ERROR_INVALID_CONTENT_LENGTH},
+		{"empty content-length", "Content-Length: \r\n", 400, ERROR_INVALID_CONTENT_LENGTH},
+		{"overflowing content-length", "Content-Length: 99999999999999999999\r\n", 400, ERROR_INVALID_CONTENT_LENGTH},
+		{"transfer-encoding and content-length", "Transfer-Encoding: chunked\r\nContent-Length: 5\r\n", 400, ERROR_CONFLICTING_FRAMING},
+		{"chunked twice", "Transfer-Encoding: chunked, chunked\r\n", 400, ERROR_CONFLICTING_FRAMING},
+		{"empty transfer-encoding", "Transfer-Encoding: \r\n", 400, ERROR_CONFLICTING_FRAMING},
+		{"unknown transfer coding", "Transfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n", 501, ERROR_UNSUPPORTED_CODING},
+		{"obs-fold", "Content-Length: 5\r\n 0\r\n", 400, headers.ErrorObsFold},
+		{"whitespace-prefixed field", " Transfer-Encoding: chunked\r\nContent-Length: 5\r\n", 400, headers.ErrorObsFold},
+	}
+
+	for _, tt := range tests {
+		_, err := RequestFromReader(&chunkReader{
+			data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\n" + tt.fields + "\r\nhello",
+			numBytesPerRead: 3,
+		})
+		var perr *ParseError
+		require.ErrorAs(t, err, &perr, tt.name)
+		assert.ErrorIs(t, err, tt.err, tt.name)
+		assert.Equal(t, tt.status, perr.Status, tt.name)
+	}
+}
+
+func TestReaderObsFold(t *testing.T) {
+	data := "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: one\r\n two\r\n\r\n"
+
+	// Test: Rejected by default
+	_, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+	assert.ErrorIs(t, err, headers.ErrorObsFold)
+
+	// Test: Unfolded when enabled
+	reader := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+	reader.ObsFold = headers.ObsFoldUnfold
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	long, _ := r.Headers.Get("x-long")
+	assert.Equal(t, []string{"one two"}, long)
+}
+
+func TestReaderBareLF(t *testing.T) {
+	data := "\r\n\nPOST /submit HTTP/1.1\nHost: localhost:42069\r\nTransfer-Encoding: chunked\n\n" +
+		"5\nhello\n0\nX-Sum: 5\n\n" +
+		"GET /next HTTP/1.1\nHost: localhost:42069\n\n"
+
+	// Test: Strict by default
+	_, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+	require.Error(t, err)
+
+	// Test: Lenient mode
+	reader := NewReader(&chunkReader{data: data, numBytesPerRead: 3})
+	reader.AllowBareLF = true
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+	sum, _ := r.Trailers.Get("x-sum")
+	assert.Equal(t, []string{"5"}, sum)
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	_, err = reader.ReadRequest()
+	assert.Equal(t, io.EOF, err)
 }
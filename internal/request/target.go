@@ -0,0 +1,348 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetForm is the form of a request target, as defined by RFC 9112
+// section 3.2.
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query, "/where?q=now".
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a full URI, "http://www.example.org/where?q=now", as
+	// sent to proxies.
+	AbsoluteForm
+	// AuthorityForm is a host and port, "www.example.org:443", only used by
+	// CONNECT.
+	AuthorityForm
+	// AsteriskForm is a lone "*", only used by a server-wide OPTIONS.
+	AsteriskForm
+)
+
+var ERROR_MALFORMED_TARGET = fmt.Errorf("malformed request target")
+
+// Target is a parsed request target.
+type Target struct {
+	Form TargetForm
+	// Scheme is the lowercased scheme of an absolute-form target.
+	Scheme string
+	// Authority is the host and optional port of an absolute-form or
+	// authority-form target.
+	Authority string
+	// Path is the percent-decoded path, "*" for an asterisk-form target and
+	// empty for an authority-form one.
+	Path string
+	// RawPath is the path as sent by the client.
+	RawPath string
+	// RawQuery is the query as sent by the client, without its "?".
+	RawQuery string
+	// Query holds the decoded parameters of the query.
+	Query Query
+}
+
+// Query maps query parameter names to their values, in the order they
+// appear in the target.
+type Query map[string][]string
+
+// Get returns the first value of the parameter name, or "" if there is none.
+func (q Query) Get(name string) string {
+	if v := q[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Has reports whether the parameter name is present, with or without a
+// value.
+func (q Query) Has(name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+// Segments returns the decoded "/"-separated segments of the path. Each
+// segment is decoded on its own, so an encoded "%2F" stays inside its
+// segment instead of splitting it.
+func (t Target) Segments() []string {
+	parts := strings.Split(strings.TrimPrefix(t.RawPath, "/"), "/")
+	for i, part := range parts {
+		if decoded, err := unescape(part, false); err == nil {
+			parts[i] = decoded
+		}
+	}
+	return parts
+}
+
+// ParseTarget parses the request target raw of a request with the given
+// method. A malformed target is reported as a ParseError with status 400
+// and an offset counted from the start of raw.
+func ParseTarget(method, raw string) (Target, error) {
+	switch {
+	case method == "CONNECT":
+		return parseAuthorityForm(raw)
+
+	case raw == "*":
+		if method != "OPTIONS" {
+			return Target{}, newParseError(400, 0, ERROR_MALFORMED_TARGET, "only OPTIONS requests can target *")
+		}
+		return Target{Form: AsteriskForm, Path: "*", RawPath: "*", Query: Query{}}, nil
+
+	case strings.HasPrefix(raw, "/"):
+		t := Target{Form: OriginForm}
+		if err := t.parsePathAndQuery(raw, 0); err != nil {
+			return Target{}, err
+		}
+		return t, nil
+
+	default:
+		return parseAbsoluteForm(raw)
+	}
+}
+
+// parseAbsoluteForm parses scheme "://" authority [ path ] [ "?" query ].
+// Only hierarchical URIs with an authority are accepted, since those are the
+// only ones that can name an HTTP resource.
+func parseAbsoluteForm(raw string) (Target, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok || !isScheme(scheme) {
+		return Target{}, newParseError(400, 0, ERROR_MALFORMED_TARGET, "target %q is not an absolute path or URI", raw)
+	}
+
+	offset := len(scheme) + len("://")
+	authority := rest
+	pathAndQuery := ""
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		authority, pathAndQuery = rest[:i], rest[i:]
+	}
+	if err := checkAuthority(authority, false); err != nil {
+		err.Offset += offset
+		return Target{}, err
+	}
+
+	t := Target{
+		Form:      AbsoluteForm,
+		Scheme:    strings.ToLower(scheme),
+		Authority: authority,
+	}
+	if pathAndQuery == "" || pathAndQuery[0] == '?' {
+		// An empty path stands for "/".
+		pathAndQuery = "/" + pathAndQuery
+		offset--
+	}
+	if err := t.parsePathAndQuery(pathAndQuery, offset+len(authority)); err != nil {
+		return Target{}, err
+	}
+	return t, nil
+}
+
+// parseAuthorityForm parses the host ":" port target of a CONNECT request.
+func parseAuthorityForm(raw string) (Target, error) {
+	if err := checkAuthority(raw, true); err != nil {
+		return Target{}, err
+	}
+	return Target{Form: AuthorityForm, Authority: raw, Query: Query{}}, nil
+}
+
+// parsePathAndQuery fills in the path and query of t from s, which starts
+// with "/" and sits offset bytes into the target.
+func (t *Target) parsePathAndQuery(s string, offset int) error {
+	rawPath, rawQuery, hasQuery := strings.Cut(s, "?")
+
+	if i := invalidIndex(rawPath, isPathChar); i >= 0 {
+		return newParseError(400, offset+i, ERROR_MALFORMED_TARGET, "invalid character %q in path", rawPath[i])
+	}
+	path, err := unescape(rawPath, false)
+	if err != nil {
+		err.Offset += offset
+		return err
+	}
+
+	if hasQuery {
+		queryOffset := offset + len(rawPath) + 1
+		if i := invalidIndex(rawQuery, isQueryChar); i >= 0 {
+			return newParseError(400, queryOffset+i, ERROR_MALFORMED_TARGET, "invalid character %q in query", rawQuery[i])
+		}
+		if t.Query, err = parseQuery(rawQuery); err != nil {
+			err.Offset += queryOffset
+			return err
+		}
+	} else {
+		t.Query = Query{}
+	}
+
+	t.Path = path
+	t.RawPath = rawPath
+	t.RawQuery = rawQuery
+	return nil
+}
+
+// parseQuery splits a query into "&"-separated name=value pairs, decoding
+// "+" as a space and percent-encoded bytes in both names and values.
+func parseQuery(raw string) (Query, *ParseError) {
+	q := Query{}
+	offset := 0
+	for pair := range strings.SplitSeq(raw, "&") {
+		if pair != "" {
+			rawName, rawValue, _ := strings.Cut(pair, "=")
+			name, err := unescape(rawName, true)
+			if err != nil {
+				err.Offset += offset
+				return nil, err
+			}
+			value, err := unescape(rawValue, true)
+			if err != nil {
+				err.Offset += offset + len(rawName) + 1
+				return nil, err
+			}
+			q[name] = append(q[name], value)
+		}
+		offset += len(pair) + 1
+	}
+	return q, nil
+}
+
+// unescape decodes the percent-encoded bytes of s and, if plusIsSpace is
+// set, turns "+" into a space.
+func unescape(s string, plusIsSpace bool) (string, *ParseError) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", newParseError(400, i, ERROR_MALFORMED_TARGET, "invalid percent-encoding %q", s[i:min(i+3, len(s))])
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case c == '+' && plusIsSpace:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// checkAuthority validates host [ ":" port ], where host is a registered
+// name, an IPv4 address or a bracketed IP literal. Userinfo is not allowed
+// in HTTP targets.
+func checkAuthority(authority string, portRequired bool) *ParseError {
+	if authority == "" {
+		return newParseError(400, 0, ERROR_MALFORMED_TARGET, "target has no host")
+	}
+
+	if i := strings.IndexByte(authority, '@'); i >= 0 {
+		return newParseError(400, i, ERROR_MALFORMED_TARGET, "target must not contain userinfo")
+	}
+
+	host, port, hasPort := authority, "", false
+	if strings.HasPrefix(authority, "[") {
+		end := strings.IndexByte(authority, ']')
+		if end < 0 {
+			return newParseError(400, 0, ERROR_MALFORMED_TARGET, "unterminated IP literal in %q", authority)
+		}
+		host, port = authority[:end+1], authority[end+1:]
+		if port != "" {
+			if port[0] != ':' {
+				return newParseError(400, end+1, ERROR_MALFORMED_TARGET, "unexpected %q after IP literal", port)
+			}
+			port, hasPort = port[1:], true
+		}
+		if i := invalidIndex(host[1:end], isIPLiteralChar); i >= 0 {
+			return newParseError(400, i+1, ERROR_MALFORMED_TARGET, "invalid character %q in IP literal", host[i+1])
+		}
+	} else {
+		if i := strings.LastIndexByte(authority, ':'); i >= 0 {
+			host, port, hasPort = authority[:i], authority[i+1:], true
+		}
+		if host == "" {
+			return newParseError(400, 0, ERROR_MALFORMED_TARGET, "target has no host")
+		}
+		if i := invalidIndex(host, isRegNameChar); i >= 0 {
+			return newParseError(400, i, ERROR_MALFORMED_TARGET, "invalid character %q in host", host[i])
+		}
+	}
+
+	if portRequired && (!hasPort || port == "") {
+		return newParseError(400, len(authority), ERROR_MALFORMED_TARGET, "target %q has no port", authority)
+	}
+	for i := 0; i < len(port); i++ {
+		if port[i] < '0' || port[i] > '9' {
+			return newParseError(400, len(host)+1+i, ERROR_MALFORMED_TARGET, "invalid port %q", port)
+		}
+	}
+	return nil
+}
+
+// invalidIndex returns the index of the first byte of s rejected by valid,
+// or -1 if there is none.
+func invalidIndex(s string, valid func(byte) bool) int {
+	for i := 0; i < len(s); i++ {
+		if !valid(s[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isScheme reports whether s is a scheme:
+//
+//	scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+func isScheme(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if c := s[i]; !isAlpha(c) && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isHex(c byte) bool   { return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' }
+
+func unhex(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("-._~", c) >= 0
+}
+
+func isSubDelim(c byte) bool {
+	return strings.IndexByte("!$&'()*+,;=", c) >= 0
+}
+
+// isPathChar reports whether c may appear in a path: a pchar or "/". The
+// "%" of a percent-encoding is checked by unescape.
+func isPathChar(c byte) bool {
+	return isUnreserved(c) || isSubDelim(c) || c == ':' || c == '@' || c == '%' || c == '/'
+}
+
+func isQueryChar(c byte) bool {
+	return isPathChar(c) || c == '?'
+}
+
+func isRegNameChar(c byte) bool {
+	return isUnreserved(c) || isSubDelim(c) || c == '%'
+}
+
+func isIPLiteralChar(c byte) bool {
+	return isHex(c) || c == ':' || c == '.' || isUnreserved(c)
+}
@@ -0,0 +1,124 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	// Test: Origin-form with a decoded path and a query
+	target, err := ParseTarget("GET", "/files/my%20report.pdf?download=1&tag=a&tag=b+c&empty&q=%26%3D")
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, target.Form)
+	assert.Equal(t, "/files/my report.pdf", target.Path)
+	assert.Equal(t, "/files/my%20report.pdf", target.RawPath)
+	assert.Equal(t, "download=1&tag=a&tag=b+c&empty&q=%26%3D", target.RawQuery)
+	assert.Equal(t, Query{
+		"download": {"1"},
+		"tag":      {"a", "b c"},
+		"empty":    {""},
+		"q":        {"&="},
+	}, target.Query)
+	assert.Equal(t, "a", target.Query.Get("tag"))
+	assert.True(t, target.Query.Has("empty"))
+	assert.False(t, target.Query.Has("missing"))
+
+	// Test: "+" is only a space in the query
+	target, err = ParseTarget("GET", "/c++?lang=c++")
+	require.NoError(t, err)
+	assert.Equal(t, "/c++", target.Path)
+	assert.Equal(t, "c  ", target.Query.Get("lang"))
+
+	// Test: Segments keep an encoded slash inside its segment
+	target, err = ParseTarget("GET", "/repos/a%2Fb/issues")
+	require.NoError(t, err)
+	assert.Equal(t, "/repos/a/b/issues", target.Path)
+	assert.Equal(t, []string{"repos", "a/b", "issues"}, target.Segments())
+
+	// Test: Absolute-form
+	target, err = ParseTarget("GET", "HTTP://www.example.org:8080/pub/WWW/?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, target.Form)
+	assert.Equal(t, "http", target.Scheme)
+	assert.Equal(t, "www.example.org:8080", target.Authority)
+	assert.Equal(t, "/pub/WWW/", target.Path)
+	assert.Equal(t, "1", target.Query.Get("x"))
+
+	// Test: Absolute-form with an empty path
+	target, err = ParseTarget("GET", "http://[::1]?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]", target.Authority)
+	assert.Equal(t, "/", target.Path)
+	assert.Equal(t, "x=1", target.RawQuery)
+
+	// Test: Authority-form for CONNECT
+	target, err = ParseTarget("CONNECT", "www.example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, target.Form)
+	assert.Equal(t, "www.example.com:443", target.Authority)
+	assert.Equal(t, "", target.Path)
+
+	// Test: Asterisk-form for OPTIONS
+	target, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, target.Form)
+	assert.Equal(t, "*", target.Path)
+}
+
+func TestParseTarget_Malformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		offset int
+	}{
+		{"bad percent-encoding", "GET", "/a%2", 2},
+		{"non-hex percent-encoding", "GET", "/a?x=%zz", 5},
+		{"fragment", "GET", "/page#top", 5},
+		{"control character", "GET", "/a\x01b", 2},
+		{"invalid query character", "GET", "/a?x=<b>", 5},
+		{"relative path", "GET", "index.html", 0},
+		{"asterisk without OPTIONS", "GET", "*", 0},
+		{"CONNECT without port", "CONNECT", "example.com", 11},
+		{"CONNECT with path", "CONNECT", "example.com:443/", 15},
+		{"userinfo", "GET", "http://user:pw@example.com/", 14},
+		{"absolute-form without host", "GET", "http:///path", 7},
+		{"invalid host character", "GET", "http://exa<mple.com/", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTarget(tt.method, tt.target)
+			var perr *ParseError
+			require.ErrorAs(t, err, &perr)
+			assert.ErrorIs(t, err, ERROR_MALFORMED_TARGET)
+			assert.Equal(t, 400, perr.Status)
+			assert.Equal(t, tt.offset, perr.Offset)
+		})
+	}
+}
+
+func TestRequestTarget(t *testing.T) {
+	// Test: The parsed target is set on the request
+	r, err := RequestFromReader(&chunkReader{
+		data:            "GET /search?q=tcp+sockets HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/search?q=tcp+sockets", r.RequestLine.RequestTarget)
+	assert.Equal(t, "/search", r.Target.Path)
+	assert.Equal(t, "tcp sockets", r.Target.Query.Get("q"))
+
+	// Test: A malformed target is a 400 pointing into the request line
+	_, err = RequestFromReader(&chunkReader{
+		data:            "GET /a%zz HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ERROR_MALFORMED_TARGET)
+	assert.Equal(t, 400, perr.Status)
+	assert.Equal(t, 6, perr.Offset)
+}
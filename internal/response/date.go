@@ -0,0 +1,31 @@
+package response
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mugiwara999/httpfromtcp/internal/headers"
+)
+
+// now is the clock behind the Date header.
+var now = time.Now
+
+// dateCache keeps the Date value of the current second, so that it is
+// formatted once a second rather than once a response.
+var dateCache struct {
+	sync.Mutex
+	unix  int64
+	value string
+}
+
+// httpDate returns t formatted for the Date header.
+func httpDate(t time.Time) string {
+	dateCache.Lock()
+	defer dateCache.Unlock()
+
+	if sec := t.Unix(); sec != dateCache.unix || dateCache.value == "" {
+		dateCache.unix = sec
+		dateCache.value = t.UTC().Format(headers.TimeFormat)
+	}
+	return dateCache.value
+}
@@ -1,110 +1,534 @@
 package response
 
 import (
-	"bytes"
+	"bufio"
 	"fmt"
+	"io"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/mugiwara999/httpfromtcp/internal/headers"
 )
 
-type StatusCode uint
+type WriterState string
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	WriteStateStatusLine  WriterState = "statusLine"
+	WriteStateHeaders     WriterState = "headers"
+	WriteStateBody        WriterState = "body"
+	WriteStateChunkedBody WriterState = "chunkedBody"
+	WriteStateTrailers    WriterState = "trailers"
+	WriteStateDone        WriterState = "done"
+)
+
+var (
+	ErrorResponeWrite      = fmt.Errorf("invalid order of writing response")
+	ErrorUndeclaredTrailer = fmt.Errorf("trailer field not declared in Trailer header")
+	ErrorContentLength     = fmt.Errorf("body does not match declared content-length")
+	ErrorInvalidStatus     = fmt.Errorf("status code must have three digits")
+	ErrorInvalidReason     = fmt.Errorf("reason phrase contains control characters")
 )
 
-var statusText = map[StatusCode]string{
-	StatusOK:                  "OK",
-	StatusBadRequest:          "Bad Request",
-	StatusInternalServerError: "Internal Server Error",
+// Writer writes a response straight to its destination, optionally through
+// a bufio.Writer, so handlers can stream bodies of any size. The first write
+// error is kept and returned by every later call.
+type Writer struct {
+	State WriterState
+	// OnHeaders, if set, is called just before the header section of the
+	// final response is written, while CloseConnection can still be
+	// announced in it.
+	OnHeaders func()
+
+	version   string
+	status    StatusCode
+	header    *headers.Headers
+	out       io.Writer
+	buffered  *bufio.Writer
+	err       error
+	closeConn bool
+	trailers  []string
+	remaining int64
+	// unframed is set for a chunked response to an HTTP/1.0 client, whose
+	// body is sent as is and ended by closing the connection.
+	unframed bool
+	server   string
+	// pending holds a header section that declares neither a length nor
+	// chunked framing until the body shows which one to add.
+	pending *headers.Headers
+	// autoChunked is set when the writer chose chunked framing itself, so
+	// that plain body writes are sent as chunks. chunkSent is set once a
+	// chunk has been written.
+	autoChunked bool
+	chunkSent   bool
 }
 
-type WriterState string
+// NewWriter returns a Writer that passes every write through to dst.
+func NewWriter(dst io.Writer) *Writer {
+	return &Writer{
+		State:   WriteStateStatusLine,
+		version: "1.1",
+		out:     dst,
+	}
+}
 
-const (
-	WriteStateStatusLine WriterState = "statusLine"
-	WriteStateHeaders    WriterState = "headers"
-	WriteStateBody       WriterState = "body"
-	WriteStateDone       WriterState = "done"
-)
+// NewBufferedWriter returns a Writer that collects up to size bytes before
+// writing to dst. Call Flush once the response is complete, or whenever
+// buffered output must reach the client.
+func NewBufferedWriter(dst io.Writer, size int) *Writer {
+	buffered := bufio.NewWriterSize(dst, size)
+	return &Writer{
+		State:    WriteStateStatusLine,
+		version:  "1.1",
+		out:      buffered,
+		buffered: buffered,
+	}
+}
 
-var ErrorResponeWrite = fmt.Errorf("invalid order of writing response")
+// Flush writes any buffered output to the destination. Headers still held
+// for a body of unknown length are sent first, with chunked framing.
+func (w *Writer) Flush() error {
+	if w.pending != nil {
+		w.sendPending(-1)
+	}
+	if w.err == nil && w.buffered != nil {
+		w.err = w.buffered.Flush()
+	}
+	return w.err
+}
 
-type Writer struct {
-	Buf   bytes.Buffer
-	State WriterState
+// SetVersion sets the HTTP version the response is written for, "1.1" by
+// default. For "1.0" the status line says HTTP/1.0, a persistent connection
+// is announced with "connection: keep-alive", and a chunked body is sent
+// without chunk framing or trailers and ended by closing the connection.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
+}
+
+// SetServer sets the Server header added to responses that do not carry
+// one. None is added by default.
+func (w *Writer) SetServer(server string) {
+	w.server = server
+}
+
+// Finish completes a response whose framing was chosen by the writer: held
+// headers are sent with "content-length: 0", and an automatically chunked
+// body gets its last chunk. Other responses are left as they are.
+func (w *Writer) Finish() error {
+	switch {
+	case w.pending != nil:
+		return w.sendPending(0)
+	case w.autoChunked && w.State == WriteStateChunkedBody:
+		return w.WriteChunkedBodyDone()
+	}
+	return w.err
+}
+
+// CloseConnection marks the response as the last one on its connection.
+// WriteHeaders announces it with a "connection: close" header.
+func (w *Writer) CloseConnection() {
+	w.closeConn = true
 }
 
-func NewWriter() *Writer {
-	return &Writer{State: WriteStateStatusLine}
+// ShouldClose reports whether the connection must be closed once the
+// response has been sent, either because it was asked to or because the
+// headers leave the end of the body to be signalled by closing.
+func (w *Writer) ShouldClose() bool {
+	return w.closeConn
 }
 
+// Header returns fields that WriteHeaders sends in addition to the ones it
+// is given, so code wrapping a handler can add headers before the handler
+// writes its response. A field passed to WriteHeaders replaces one of the
+// same name set here.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// StatusCode returns the status written by WriteStatusLine, or 0 if the
+// response has not been started.
+func (w *Writer) StatusCode() StatusCode {
+	return w.status
+}
+
+// Write writes part of the body. In a content-length body the bytes count
+// against the declared length, which they cannot exceed, and the response
+// is done once it has been reached. In a chunked body every write is sent
+// as a chunk. If the headers declared no framing, the body is chunked.
+// Writing outside the body is an error.
 func (w *Writer) Write(p []byte) (int, error) {
-	return w.Buf.Write(p)
+	if w.pending != nil {
+		w.sendPending(-1)
+	}
+
+	switch w.State {
+	case WriteStateChunkedBody:
+		return w.WriteChunkedBody(p)
+	case WriteStateBody:
+		if int64(len(p)) > w.remaining {
+			return 0, ErrorContentLength
+		}
+		w.remaining -= int64(len(p))
+		if w.remaining == 0 {
+			w.State = WriteStateDone
+		}
+		return w.write(p)
+	}
+	return 0, ErrorResponeWrite
+}
+
+func (w *Writer) write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.out.Write(p)
+	w.err = err
+	return n, err
 }
 
+func (w *Writer) printf(format string, a ...any) error {
+	if w.err != nil {
+		return w.err
+	}
+	_, w.err = fmt.Fprintf(w.out, format, a...)
+	return w.err
+}
+
+// WriteStatusLine writes the status line with the registered reason phrase
+// for code, or an empty one if code is not registered.
 func (w *Writer) WriteStatusLine(code StatusCode) error {
+	return w.WriteStatusLineReason(code, statusText[code])
+}
+
+// WriteStatusLineReason writes the status line with a custom reason phrase.
+func (w *Writer) WriteStatusLineReason(code StatusCode, reason string) error {
 	if w.State != WriteStateStatusLine {
 		return ErrorResponeWrite
 	}
-	text := statusText[code]
-	line := fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, text)
-	_, err := w.Buf.WriteString(line)
+	if code < 100 || code > 999 {
+		return ErrorInvalidStatus
+	}
+	if !isValidReason(reason) {
+		return ErrorInvalidReason
+	}
+
+	err := w.printf("HTTP/%s %d %s\r\n", w.version, code, reason)
+	w.status = code
 	w.State = WriteStateHeaders
 	return err
 }
 
-func (w *Writer) WriteHeaders(h headers.Headers) error {
+// WriteInformational writes an interim 1xx response, such as 100 Continue or
+// 103 Early Hints with Link fields, ahead of the final one, which must not
+// have been started. It does nothing for HTTP/1.0 clients, which do not
+// understand interim responses. 101 Switching Protocols is not interim and
+// is rejected.
+func (w *Writer) WriteInformational(code StatusCode, h *headers.Headers) error {
+	if w.State != WriteStateStatusLine {
+		return ErrorResponeWrite
+	}
+	if !code.IsInformational() || code == StatusSwitchingProtocols {
+		return ErrorInvalidStatus
+	}
+	if w.version == "1.0" {
+		return nil
+	}
+
+	w.printf("HTTP/%s %d %s\r\n", w.version, code, statusText[code])
+	w.writeFields(h)
+	return w.printf("\r\n")
+}
+
+// isValidReason reports whether reason matches
+// reason-phrase = *( HTAB / SP / VCHAR / obs-text ).
+func isValidReason(reason string) bool {
+	for i := 0; i < len(reason); i++ {
+		if b := reason[i]; b != '\t' && (b < ' ' || b == 0x7f) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteHeaders writes the header section, adding Date, and Server if one
+// was set with SetServer. A 1xx, 204 or 304 response ends here, whatever its
+// headers say. Otherwise, a response that declares a Trailer field or a
+// chunked Transfer-Encoding switches the writer to chunked mode:
+// "transfer-encoding: chunked" is added when missing, any content-length is
+// dropped, and the body must then be written with WriteChunkedBody.
+//
+// A response that declares neither a valid content-length nor chunked
+// framing has its headers held until the body is written. WriteBody then
+// adds the content-length of its body, while Write, WriteChunkedBody and
+// Flush switch to chunked mode; Finish sends "content-length: 0" if nothing
+// was written.
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
 	if w.State != WriteStateHeaders {
 		return ErrorResponeWrite
 	}
-	for n, v := range h {
+	h = w.withPresetHeader(h)
+
+	w.trailers = h.Elements("trailer")
+	noBody := !w.status.bodyAllowed()
+	chunked := !noBody && (hasToken(h, "transfer-encoding", "chunked") || len(w.trailers) > 0)
+
+	hasLength := false
+	if n := headerValues(h, "content-length"); !chunked && len(n) > 0 {
+		l, err := strconv.ParseInt(n[0], 10, 64)
+		hasLength = err == nil && l >= 0
+		w.remaining = l
+	}
+	if !hasLength && !chunked && !noBody {
+		w.pending = h
+		w.State = WriteStateBody
+		return w.err
+	}
+	return w.writeHeaderSection(h, chunked, false)
+}
+
+// sendPending writes the held header section with a content-length of
+// length, or with chunked framing if length is negative.
+func (w *Writer) sendPending(length int64) error {
+	h := w.pending
+	w.pending = nil
+	if length < 0 {
+		w.autoChunked = true
+		return w.writeHeaderSection(h, true, false)
+	}
+	w.remaining = length
+	return w.writeHeaderSection(h, false, true)
+}
+
+// writeHeaderSection writes h and the fields the writer adds to it, and moves
+// on to the body. With setLength, h carries no usable content-length and one
+// for w.remaining bytes is added.
+func (w *Writer) writeHeaderSection(h *headers.Headers, chunked, setLength bool) error {
+	if w.OnHeaders != nil {
+		w.OnHeaders()
+	}
+	conn := headerValues(h, "connection")
+	if hasToken(h, "connection", "close") {
+		w.closeConn = true
+	}
+	chunkedTE := hasToken(h, "transfer-encoding", "chunked")
+	w.unframed = chunked && w.version == "1.0"
+	if w.unframed {
+		w.closeConn = true
+	}
+
+	h.ForEach(func(n string, v []string) {
+		if (chunked || setLength) && strings.EqualFold(n, "content-length") {
+			return
+		}
+		if w.unframed && (strings.EqualFold(n, "transfer-encoding") || strings.EqualFold(n, "trailer")) {
+			return
+		}
 		for _, val := range v {
-			fmt.Fprintf(&w.Buf, "%s: %s\r\n", n, val)
+			w.printf("%s: %s\r\n", n, val)
 		}
+	})
+	if len(headerValues(h, "date")) == 0 {
+		w.printf("date: %s\r\n", httpDate(now()))
+	}
+	if w.server != "" && len(headerValues(h, "server")) == 0 {
+		w.printf("server: %s\r\n", w.server)
 	}
-	w.Buf.WriteString("\r\n")
+	if setLength {
+		w.printf("content-length: %d\r\n", w.remaining)
+	}
+	if chunked && !chunkedTE && !w.unframed {
+		w.printf("transfer-encoding: chunked\r\n")
+	}
+	if len(conn) == 0 {
+		if w.closeConn {
+			w.printf("connection: close\r\n")
+		} else if w.version == "1.0" {
+			w.printf("connection: keep-alive\r\n")
+		}
+	}
+	err := w.printf("\r\n")
 
-	if n, ok := h.Get("content-length"); ok && len(n) > 0 && len(n[0]) > 0 {
+	switch {
+	case !w.status.bodyAllowed():
+		w.State = WriteStateDone
+	case chunked:
+		w.State = WriteStateChunkedBody
+	case w.remaining > 0:
 		w.State = WriteStateBody
-	} else {
+	default:
 		w.State = WriteStateDone
 	}
-	return nil
+	return err
 }
 
+// WriteBody writes b as the whole body, or what is left of it after Write,
+// which must match the declared content-length. If the headers declared no
+// framing, b sets the content-length, or is sent as the only chunk once the
+// writer has switched to chunked mode, provided Write has sent none yet.
 func (w *Writer) WriteBody(b []byte) error {
+	if w.pending != nil {
+		if err := w.sendPending(int64(len(b))); err != nil {
+			return err
+		}
+		if len(b) == 0 {
+			return nil
+		}
+	}
+	if w.autoChunked && w.State == WriteStateChunkedBody {
+		if w.chunkSent {
+			return ErrorResponeWrite
+		}
+		if _, err := w.WriteChunkedBody(b); err != nil {
+			return err
+		}
+		return w.WriteChunkedBodyDone()
+	}
 	if w.State != WriteStateBody {
 		return ErrorResponeWrite
 	}
-	w.Buf.Write(b)
+	if int64(len(b)) != w.remaining {
+		return ErrorContentLength
+	}
+	_, err := w.write(b)
+	w.remaining = 0
 	w.State = WriteStateDone
-	return nil
+	return err
 }
 
-func GetDefaultHeader(contentLen int) headers.Headers {
+func GetDefaultHeader(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
 
-	h["content-length"] = []string{strconv.Itoa(contentLen)}
-	h["connection"] = []string{"close"}
-	h["content-type"] = []string{"text/plain"}
+	h.Set("content-length", strconv.Itoa(contentLen))
+	h.Set("content-type", "text/plain")
 
 	return h
 }
 
-func (w *Writer) Read(p []byte) (int, error) {
-	return w.Buf.Read(p)
+// WriteChunkedBody writes p as a single chunk. Empty writes are skipped,
+// since a zero-sized chunk would end the body.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.pending != nil {
+		w.sendPending(-1)
+	}
+	if w.State != WriteStateChunkedBody {
+		return 0, ErrorResponeWrite
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.chunkSent = true
+	if w.unframed {
+		return w.write(p)
+	}
+
+	w.printf("%x\r\n", len(p))
+	w.write(p)
+	if err := w.printf("\r\n"); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteChunkedBodyDone writes the last chunk. If the headers declared
+// trailer fields the response is finished by WriteTrailer, otherwise it is
+// complete.
+func (w *Writer) WriteChunkedBodyDone() error {
+	if w.pending != nil {
+		return w.sendPending(0)
+	}
+	if w.State != WriteStateChunkedBody {
+		return ErrorResponeWrite
+	}
+
+	if w.unframed {
+		w.State = WriteStateDone
+		if len(w.trailers) > 0 {
+			w.State = WriteStateTrailers
+		}
+		return nil
+	}
+
+	err := w.printf("0\r\n")
+	if len(w.trailers) > 0 {
+		w.State = WriteStateTrailers
+		return err
+	}
+
+	err = w.printf("\r\n")
+	w.State = WriteStateDone
+	return err
+}
+
+// WriteTrailer writes the trailer section after WriteChunkedBodyDone. Every
+// field must have been named in the Trailer header. Trailers are dropped for
+// HTTP/1.0 clients.
+func (w *Writer) WriteTrailer(h *headers.Headers) error {
+	if w.State != WriteStateTrailers {
+		return ErrorResponeWrite
+	}
+	declared := true
+	h.ForEach(func(n string, _ []string) {
+		if !slices.ContainsFunc(w.trailers, func(t string) bool { return strings.EqualFold(t, n) }) {
+			declared = false
+		}
+	})
+	if !declared {
+		return ErrorUndeclaredTrailer
+	}
+
+	if w.unframed {
+		w.State = WriteStateDone
+		return nil
+	}
+
+	w.writeFields(h)
+	err := w.printf("\r\n")
+	w.State = WriteStateDone
+	return err
+}
+
+// writeFields writes the field lines of h in order.
+func (w *Writer) writeFields(h *headers.Headers) {
+	h.ForEach(func(n string, v []string) {
+		for _, val := range v {
+			w.printf("%s: %s\r\n", n, val)
+		}
+	})
 }
 
-func (w *Writer) WriteTrailer(h headers.Headers) error {
-	for n, v := range h {
+func (w *Writer) withPresetHeader(h *headers.Headers) *headers.Headers {
+	if w.header.Len() == 0 {
+		return h
+	}
+
+	merged := headers.NewHeaders()
+	add := func(n string, v []string) {
 		for _, val := range v {
-			fmt.Fprintf(&w.Buf, "%s: %s\r\n", n, val)
+			merged.Set(n, val)
 		}
 	}
-	w.Buf.WriteString("\r\n")
-	return nil
+	w.header.ForEach(func(n string, v []string) {
+		if len(headerValues(h, n)) == 0 {
+			add(n, v)
+		}
+	})
+	h.ForEach(add)
+	return merged
+}
+
+// headerValues returns the values of the field called name in h.
+func headerValues(h *headers.Headers, name string) []string {
+	values, _ := h.Get(name)
+	return values
+}
+
+// hasToken reports whether the list-valued field called name in h has
+// token as an element, in any casing.
+func hasToken(h *headers.Headers, name, token string) bool {
+	return slices.ContainsFunc(h.Elements(name), func(t string) bool {
+		return strings.EqualFold(t, token)
+	})
 }
@@ -0,0 +1,547 @@
+package response
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mugiwara999/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDate is the Date header of every response written by the tests.
+const testDate = "Sun, 06 Nov 1994 08:49:37 GMT"
+
+func TestMain(m *testing.M) {
+	now = func() time.Time { return time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC) }
+	os.Exit(m.Run())
+}
+
+func TestChunkedBody_WithTrailers(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Set("content-length", "10")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, WriteStateChunkedBody, w.State)
+
+	n, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte(" world!!!!!!"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteChunkedBodyDone())
+	assert.Equal(t, WriteStateTrailers, w.State)
+
+	trailer := headers.NewHeaders()
+	trailer.Set("x-checksum", "abc")
+	require.NoError(t, w.WriteTrailer(trailer))
+	assert.Equal(t, WriteStateDone, w.State)
+	assert.False(t, w.ShouldClose())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Trailer: X-Checksum\r\n"+
+		"date: "+testDate+"\r\n"+
+		"transfer-encoding: chunked\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n"+
+		"c\r\n world!!!!!!\r\n"+
+		"0\r\n"+
+		"x-checksum: abc\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestChunkedBody_WithoutTrailers(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Set("transfer-encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+
+	_, err := w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteChunkedBodyDone())
+	assert.Equal(t, WriteStateDone, w.State)
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"transfer-encoding: chunked\r\n"+
+		"date: "+testDate+"\r\n"+
+		"\r\n"+
+		"3\r\nabc\r\n"+
+		"0\r\n"+
+		"\r\n", buf.String())
+
+	// Trailers cannot be written once the body is complete
+	assert.Equal(t, ErrorResponeWrite, w.WriteTrailer(headers.NewHeaders()))
+}
+
+func TestChunkedBody_InvalidOrder(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.WriteChunkedBody([]byte("abc"))
+	assert.Equal(t, ErrorResponeWrite, err)
+
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeader(3)))
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	assert.Equal(t, ErrorResponeWrite, err)
+	assert.Equal(t, ErrorResponeWrite, w.WriteChunkedBodyDone())
+}
+
+func TestChunkedBody_UndeclaredTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Set("trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteChunkedBodyDone())
+
+	trailer := headers.NewHeaders()
+	trailer.Set("x-other", "abc")
+	assert.Equal(t, ErrorUndeclaredTrailer, w.WriteTrailer(trailer))
+	assert.Equal(t, WriteStateTrailers, w.State)
+}
+
+func TestContentLengthBody_Write(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewBufferedWriter(&buf, 4096)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeader(6)))
+	assert.Equal(t, WriteStateBody, w.State)
+
+	_, err := w.Write([]byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, WriteStateBody, w.State)
+	_, err = w.Write([]byte("defg"))
+	assert.Equal(t, ErrorContentLength, err)
+	_, err = w.Write([]byte("def"))
+	require.NoError(t, err)
+	assert.Equal(t, WriteStateDone, w.State)
+
+	// Nothing reaches the destination before Flush
+	assert.Equal(t, 0, buf.Len())
+	require.NoError(t, w.Flush())
+	assert.Contains(t, buf.String(), "\r\n\r\nabcdef")
+}
+
+func TestContentLengthBody_Overrun(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeader(2)))
+
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+	n, err := w.Write([]byte("EXTRA"))
+	assert.Equal(t, ErrorResponeWrite, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nok")))
+}
+
+func TestContentLengthBody_WriteBody(t *testing.T) {
+	for _, body := range []string{"toolong", "o", ""} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(GetDefaultHeader(2)))
+
+		assert.Equal(t, ErrorContentLength, w.WriteBody([]byte(body)), body)
+		assert.Equal(t, WriteStateBody, w.State, body)
+		assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n")), body)
+	}
+
+	// Test: WriteBody completes a body started with Write
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeader(5)))
+	_, err := w.Write([]byte("he"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteBody([]byte("llo")))
+	assert.Equal(t, WriteStateDone, w.State)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\nhello")))
+}
+
+func TestChunkedBody_Write(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+
+	n, err := w.Write([]byte("raw"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	require.NoError(t, w.WriteChunkedBodyDone())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n3\r\nraw\r\n0\r\n\r\n")))
+
+	// Test: Nothing can be written once the body is done
+	_, err = w.Write([]byte("late"))
+	assert.Equal(t, ErrorResponeWrite, err)
+	_, err = NewWriter(&buf).Write([]byte("early"))
+	assert.Equal(t, ErrorResponeWrite, err)
+}
+
+func TestStatusLine(t *testing.T) {
+	tests := []struct {
+		code StatusCode
+		want string
+	}{
+		{StatusCreated, "HTTP/1.1 201 Created\r\n"},
+		{StatusMovedPermanently, "HTTP/1.1 301 Moved Permanently\r\n"},
+		{StatusNotFound, "HTTP/1.1 404 Not Found\r\n"},
+		{StatusTooManyRequests, "HTTP/1.1 429 Too Many Requests\r\n"},
+		{StatusServiceUnavailable, "HTTP/1.1 503 Service Unavailable\r\n"},
+		{599, "HTTP/1.1 599 \r\n"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		require.NoError(t, w.WriteStatusLine(tt.code))
+		assert.Equal(t, tt.want, buf.String())
+		assert.Equal(t, tt.code, w.StatusCode())
+	}
+}
+
+func TestStatusLine_CustomReason(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLineReason(StatusOK, "All Good"))
+	assert.Equal(t, "HTTP/1.1 200 All Good\r\n", buf.String())
+
+	w = NewWriter(&buf)
+	assert.Equal(t, ErrorInvalidReason, w.WriteStatusLineReason(StatusOK, "OK\r\nX-Injected: 1"))
+	assert.Equal(t, ErrorInvalidStatus, w.WriteStatusLineReason(42, "Nope"))
+	assert.Equal(t, WriteStateStatusLine, w.State)
+}
+
+func TestStatusCode_Classes(t *testing.T) {
+	assert.True(t, StatusEarlyHints.IsInformational())
+	assert.True(t, StatusNoContent.IsSuccess())
+	assert.True(t, StatusNotModified.IsRedirect())
+	assert.True(t, StatusMisdirectedRequest.IsClientError())
+	assert.True(t, StatusGatewayTimeout.IsServerError())
+	assert.True(t, StatusGatewayTimeout.IsError())
+	assert.False(t, StatusOK.IsError())
+	assert.False(t, StatusCode(600).IsServerError())
+}
+
+func TestNoContent_KeepsConnection(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+
+	assert.Equal(t, WriteStateDone, w.State)
+	assert.False(t, w.ShouldClose())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\ndate: "+testDate+"\r\n\r\n", buf.String())
+}
+
+func TestHTTP10_ChunkedBecomesUnframed(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Set("trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteChunkedBodyDone())
+
+	trailer := headers.NewHeaders()
+	trailer.Set("x-checksum", "abc")
+	require.NoError(t, w.WriteTrailer(trailer))
+
+	assert.Equal(t, WriteStateDone, w.State)
+	assert.True(t, w.ShouldClose())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n"+
+		"date: "+testDate+"\r\n"+
+		"connection: close\r\n"+
+		"\r\n"+
+		"hello", buf.String())
+}
+
+func TestHTTP10_KeepAlive(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Set("content-length", "2")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteBody([]byte("ok")))
+
+	assert.False(t, w.ShouldClose())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n"+
+		"content-length: 2\r\n"+
+		"date: "+testDate+"\r\n"+
+		"connection: keep-alive\r\n"+
+		"\r\n"+
+		"ok", buf.String())
+}
+
+func TestInformational(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+
+	hints := headers.NewHeaders()
+	hints.Set("link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, hints))
+	assert.Equal(t, WriteStateStatusLine, w.State)
+
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 204 No Content\r\ndate: "+testDate+"\r\n\r\n", buf.String())
+
+	// Test: Only interim codes, and only before the final response
+	assert.ErrorIs(t, NewWriter(&buf).WriteInformational(StatusOK, nil), ErrorInvalidStatus)
+	assert.ErrorIs(t, NewWriter(&buf).WriteInformational(StatusSwitchingProtocols, nil), ErrorInvalidStatus)
+	assert.ErrorIs(t, w.WriteInformational(StatusContinue, nil), ErrorResponeWrite)
+
+	// Test: Skipped for HTTP/1.0 clients
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	assert.Empty(t, buf.String())
+}
+
+func TestDateAndServer(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetServer("httpfromtcp")
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n"+
+		"date: "+testDate+"\r\n"+
+		"server: httpfromtcp\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Fields set by the handler are kept
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetServer("httpfromtcp")
+	h := headers.NewHeaders()
+	h.Set("date", "Mon, 07 Nov 1994 08:49:37 GMT")
+	h.Set("server", "custom")
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.NotContains(t, buf.String(), testDate)
+	assert.NotContains(t, buf.String(), "httpfromtcp")
+}
+
+func TestHTTPDate_CachedPerSecond(t *testing.T) {
+	t0 := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", httpDate(t0))
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", httpDate(t0.Add(999*time.Millisecond)))
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:01 GMT", httpDate(t0.Add(time.Second)))
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:01 GMT", httpDate(t0.Add(time.Second).In(time.FixedZone("CET", 3600))))
+}
+
+func TestUndeclaredLength_WriteBody(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+
+	// The headers wait for the body
+	assert.Equal(t, WriteStateBody, w.State)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+
+	require.NoError(t, w.WriteBody([]byte("hello")))
+	assert.Equal(t, WriteStateDone, w.State)
+	assert.False(t, w.ShouldClose())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"date: "+testDate+"\r\n"+
+		"content-length: 5\r\n"+
+		"\r\n"+
+		"hello", buf.String())
+
+	// Test: An invalid content-length is replaced
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := headers.NewHeaders()
+	h.Set("content-length", "lots")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	assert.NotContains(t, buf.String(), "lots")
+	assert.Contains(t, buf.String(), "content-length: 2\r\n\r\nhi")
+}
+
+func TestUndeclaredLength_Write(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, WriteStateChunkedBody, w.State)
+	_, err = w.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, WriteStateDone, w.State)
+	assert.False(t, w.ShouldClose())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"date: "+testDate+"\r\n"+
+		"transfer-encoding: chunked\r\n"+
+		"\r\n"+
+		"5\r\nhello\r\n"+
+		"6\r\n world\r\n"+
+		"0\r\n\r\n", buf.String())
+
+	// Test: WriteBody cannot add the only chunk once Write has sent one
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	assert.Equal(t, ErrorResponeWrite, w.WriteBody([]byte("rest")))
+	assert.Equal(t, WriteStateChunkedBody, w.State)
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n7\r\npartial\r\n")))
+
+	// Test: HTTP/1.0 clients get the body up to the closing of the connection
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.ShouldClose())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n"+
+		"date: "+testDate+"\r\n"+
+		"connection: close\r\n"+
+		"\r\n"+
+		"hello", buf.String())
+}
+
+func TestUndeclaredLength_Finish(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, WriteStateDone, w.State)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"date: "+testDate+"\r\n"+
+		"content-length: 0\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Flush sends the headers, and the body can still be written
+	buf.Reset()
+	w = NewBufferedWriter(&buf, 4096)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	require.NoError(t, w.Flush())
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n\r\n")
+	require.NoError(t, w.WriteBody([]byte("late")))
+	assert.Equal(t, WriteStateDone, w.State)
+	require.NoError(t, w.Flush())
+	assert.True(t, bytes.HasSuffix(buf.Bytes(), []byte("\r\n\r\n4\r\nlate\r\n0\r\n\r\n")))
+}
+
+func TestWriteHeaders_Golden(t *testing.T) {
+	for range 20 {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetServer("httpfromtcp")
+		w.Header().Set("X-Request-Id", "abc")
+		w.Header().Set("Cache-Control", "no-store")
+
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/html")
+		h.Set("Set-Cookie", "a=1")
+		h.Set("set-cookie", "b=2")
+		h.Set("cache-control", "private")
+		h.Set("Content-Length", "2")
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		require.NoError(t, w.WriteBody([]byte("ok")))
+
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+			"X-Request-Id: abc\r\n"+
+			"Content-Type: text/html\r\n"+
+			"Set-Cookie: a=1\r\n"+
+			"Set-Cookie: b=2\r\n"+
+			"cache-control: private\r\n"+
+			"Content-Length: 2\r\n"+
+			"date: "+testDate+"\r\n"+
+			"server: httpfromtcp\r\n"+
+			"\r\n"+
+			"ok", buf.String())
+	}
+}
+
+func TestWriteHeaders_TrailerCasing(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	h := headers.NewHeaders()
+	h.Set("Trailer", "X-Checksum")
+	h.Set("trailer", "X-Length")
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteChunkedBodyDone())
+
+	trailer := headers.NewHeaders()
+	trailer.Set("X-Length", "0")
+	trailer.Set("x-checksum", "abc")
+	require.NoError(t, w.WriteTrailer(trailer))
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Trailer: X-Checksum\r\n"+
+		"Trailer: X-Length\r\n"+
+		"date: "+testDate+"\r\n"+
+		"transfer-encoding: chunked\r\n"+
+		"\r\n"+
+		"0\r\n"+
+		"X-Length: 0\r\n"+
+		"x-checksum: abc\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestOnHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	calls := 0
+	w.OnHeaders = func() {
+		calls++
+		w.CloseConnection()
+	}
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+
+	// Test: Held headers are only written, and announced, with the body
+	assert.Equal(t, 0, calls)
+	require.NoError(t, w.WriteBody([]byte("ok")))
+	assert.Equal(t, 1, calls)
+	assert.Contains(t, buf.String(), "content-length: 2\r\nconnection: close\r\n\r\nok")
+}
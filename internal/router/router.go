@@ -0,0 +1,195 @@
+package router
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mugiwara999/httpfromtcp/internal/headers"
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/mugiwara999/httpfromtcp/internal/server"
+)
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	wildcardSegment
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Patterns are made of "/"-separated segments, each of which is
+// either static text, a "{name}" capture of a single segment, or, as the
+// last segment only, a "*" wildcard capturing the rest of the path. The
+// wildcard needs the slash before it: "/static/*" matches "/static/" with
+// an empty capture, but not "/static".
+//
+// When several patterns match a path, the one whose segments are the most
+// specific from left to right wins: static beats "{name}", which beats "*".
+type Router struct {
+	routes []route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for requests with the given method whose path
+// matches pattern. It panics if the pattern is malformed or has already been
+// registered for method.
+func (r *Router) Handle(method, pattern string, handler server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, rt := range r.routes {
+		if rt.method == method && rt.pattern == pattern {
+			panic(fmt.Sprintf("router: %s %s registered twice", method, pattern))
+		}
+	}
+
+	r.routes = append(r.routes, route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+// Serve is a server.Handler that runs the handler of the best matching
+// route for the decoded segments of the request path. It answers 404 when
+// no pattern matches the path, and 405 with an Allow header when patterns
+// match but none for the request method.
+func (r *Router) Serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	parts := req.Target.Segments()
+
+	var best *route
+	var bestParams map[string]string
+	allowed := []string{}
+
+	for i := range r.routes {
+		rt := &r.routes[i]
+		params, ok := rt.match(parts)
+		if !ok {
+			continue
+		}
+		if !slices.Contains(allowed, rt.method) {
+			allowed = append(allowed, rt.method)
+		}
+		if rt.method != req.RequestLine.Method {
+			continue
+		}
+		if best == nil || rt.moreSpecificThan(best) {
+			best = rt
+			bestParams = params
+		}
+	}
+
+	if best == nil {
+		if len(allowed) > 0 {
+			h := headers.NewHeaders()
+			h.Set("allow", strings.Join(allowed, ", "))
+			return &server.HandlerError{
+				Status:  response.StatusMethodNotAllowed,
+				Message: "Method Not Allowed",
+				Headers: h,
+			}
+		}
+		return &server.HandlerError{
+			Status:  response.StatusNotFound,
+			Message: "Not Found",
+		}
+	}
+
+	req.Params = bestParams
+	return best.handler(w, req)
+}
+
+func (rt *route) match(parts []string) (map[string]string, bool) {
+	params := map[string]string{}
+
+	for i, seg := range rt.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		if seg.kind == wildcardSegment {
+			params["*"] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+
+		switch seg.kind {
+		case staticSegment:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case paramSegment:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+
+	if len(parts) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+func (rt *route) moreSpecificThan(other *route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		if rt.segments[i].kind != other.segments[i].kind {
+			return rt.segments[i].kind < other.segments[i].kind
+		}
+	}
+	return len(rt.segments) > len(other.segments)
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("router: pattern %q must start with /", pattern)
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+
+	for i, part := range parts {
+		switch {
+		case part == "*":
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("router: wildcard must be the last segment of %q", pattern)
+			}
+			segments = append(segments, segment{kind: wildcardSegment})
+
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			if name == "" || strings.ContainsAny(name, "{}") {
+				return nil, fmt.Errorf("router: invalid parameter %q in %q", part, pattern)
+			}
+			segments = append(segments, segment{kind: paramSegment, value: name})
+
+		case strings.ContainsAny(part, "{}*"):
+			return nil, fmt.Errorf("router: invalid segment %q in %q", part, pattern)
+
+		default:
+			segments = append(segments, segment{kind: staticSegment, value: part})
+		}
+	}
+
+	return segments, nil
+}
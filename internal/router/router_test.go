@@ -0,0 +1,113 @@
+package router
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/mugiwara999/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(method, target string) *request.Request {
+	parsed, err := request.ParseTarget(method, target)
+	if err != nil {
+		panic(err)
+	}
+	return &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        method,
+			RequestTarget: target,
+			HttpVersion:   "1.1",
+		},
+		Target: parsed,
+	}
+}
+
+func named(name string, got *string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		*got = name
+		return nil
+	}
+}
+
+func TestRouter_Match(t *testing.T) {
+	var got string
+	r := New()
+	r.Handle("GET", "/", named("root", &got))
+	r.Handle("GET", "/users/{id}", named("user", &got))
+	r.Handle("GET", "/users/me", named("me", &got))
+	r.Handle("GET", "/users/{id}/posts/{post}", named("post", &got))
+	r.Handle("GET", "/static/*", named("static", &got))
+
+	tests := []struct {
+		target string
+		name   string
+		params map[string]string
+	}{
+		{"/", "root", map[string]string{}},
+		{"/users/42", "user", map[string]string{"id": "42"}},
+		{"/users/me", "me", map[string]string{}},
+		{"/users/42/posts/7?page=2", "post", map[string]string{"id": "42", "post": "7"}},
+		{"/users/j%20doe", "user", map[string]string{"id": "j doe"}},
+		{"/static/css/site.css", "static", map[string]string{"*": "css/site.css"}},
+		{"/static/", "static", map[string]string{"*": ""}},
+	}
+
+	for _, tt := range tests {
+		got = ""
+		req := newRequest("GET", tt.target)
+		herr := r.Serve(response.NewWriter(&bytes.Buffer{}), req)
+		require.Nil(t, herr, tt.target)
+		assert.Equal(t, tt.name, got, tt.target)
+		assert.Equal(t, tt.params, req.Params, tt.target)
+	}
+}
+
+func TestRouter_NotFound(t *testing.T) {
+	var got string
+	r := New()
+	r.Handle("GET", "/users/{id}", named("user", &got))
+	r.Handle("GET", "/static/*", named("static", &got))
+
+	for _, target := range []string{"/users", "/users/", "/users/42/extra", "/other", "/static"} {
+		herr := r.Serve(response.NewWriter(&bytes.Buffer{}), newRequest("GET", target))
+		require.NotNil(t, herr, target)
+		assert.Equal(t, response.StatusNotFound, herr.Status, target)
+	}
+	assert.Equal(t, "", got)
+}
+
+func TestRouter_MethodNotAllowed(t *testing.T) {
+	var got string
+	r := New()
+	r.Handle("GET", "/items/{id}", named("get", &got))
+	r.Handle("DELETE", "/items/{id}", named("delete", &got))
+	r.Handle("POST", "/items/*", named("post", &got))
+
+	herr := r.Serve(response.NewWriter(&bytes.Buffer{}), newRequest("PUT", "/items/1"))
+	require.NotNil(t, herr)
+	assert.Equal(t, response.StatusMethodNotAllowed, herr.Status)
+	allow, ok := herr.Headers.Get("allow")
+	require.True(t, ok)
+	assert.Equal(t, []string{"GET, DELETE, POST"}, allow)
+
+	herr = r.Serve(response.NewWriter(&bytes.Buffer{}), newRequest("DELETE", "/items/1"))
+	require.Nil(t, herr)
+	assert.Equal(t, "delete", got)
+}
+
+func TestRouter_InvalidPatterns(t *testing.T) {
+	r := New()
+	h := func(w *response.Writer, req *request.Request) *server.HandlerError { return nil }
+
+	assert.Panics(t, func() { r.Handle("GET", "users", h) })
+	assert.Panics(t, func() { r.Handle("GET", "/files/*/meta", h) })
+	assert.Panics(t, func() { r.Handle("GET", "/users/{}", h) })
+	assert.Panics(t, func() { r.Handle("GET", "/users/{id", h) })
+
+	r.Handle("GET", "/users/{id}", h)
+	assert.Panics(t, func() { r.Handle("GET", "/users/{id}", h) })
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+)
+
+// maxPipelinedRequests bounds how many requests of one connection may be
+// read and handled ahead of the response currently being written.
+const maxPipelinedRequests = 16
+
+const writeBufferSize = 4096
+
+// serverConn is a connection tracked by its Server. Its fields are guarded
+// by the Server's mutex.
+type serverConn struct {
+	net.Conn
+
+	// waiting is set while the connection waits for the first bytes of its
+	// next request.
+	waiting bool
+	// pending counts requests that have been read but whose responses have
+	// not been fully sent.
+	pending int
+}
+
+// idle reports whether the connection can be closed without losing a
+// request or response.
+func (c *serverConn) idle() bool {
+	return c.waiting && c.pending == 0
+}
+
+func (s *Server) setWaiting(c *serverConn, waiting bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.waiting = waiting
+	s.startIdle(c)
+}
+
+func (s *Server) addPending(c *serverConn, delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.pending += delta
+	s.startIdle(c)
+}
+
+// startIdle starts the idle timeout of c once it is waiting for its next
+// request and every response has been sent, so that a slow handler does not
+// use up the time the client has to send the next request. It is called
+// with the Server's mutex held.
+func (s *Server) startIdle(c *serverConn) {
+	if !c.idle() {
+		return
+	}
+	idleTimeout := s.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = s.ReadTimeout
+	}
+	c.SetReadDeadline(deadline(time.Now(), idleTimeout))
+}
+
+type pendingResponse struct {
+	w    *response.Writer
+	slot *responseSlot
+	done chan struct{}
+	err  error
+}
+
+func newPendingResponse(conn net.Conn) *pendingResponse {
+	slot := &responseSlot{conn: conn}
+	return &pendingResponse{
+		w:    response.NewBufferedWriter(slot, writeBufferSize),
+		slot: slot,
+		done: make(chan struct{}),
+	}
+}
+
+// responseSlot is the destination of one response. It keeps the output in
+// memory while earlier responses on the connection are still being sent,
+// and writes straight through to the connection once it is its turn.
+type responseSlot struct {
+	mu     sync.Mutex
+	conn   io.Writer
+	buf    bytes.Buffer
+	active bool
+}
+
+func (s *responseSlot) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active {
+		return s.conn.Write(p)
+	}
+	return s.buf.Write(p)
+}
+
+func (s *responseSlot) activate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active = true
+	_, err := s.buf.WriteTo(s.conn)
+	return err
+}
+
+// runConnection reads requests off conn as soon as they arrive and runs
+// their handlers concurrently, while responses are written back strictly
+// in the order the requests were received.
+func (s *Server) runConnection(conn *serverConn) {
+	defer s.untrackConn(conn)
+	defer conn.Close()
+
+	queue := make(chan *pendingResponse, maxPipelinedRequests)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go s.readRequests(conn, queue, quit)
+
+	for p := range queue {
+		conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout))
+		if err := p.slot.activate(); err != nil {
+			return
+		}
+		<-p.done
+		s.addPending(conn, -1)
+
+		if p.err != nil {
+			return
+		}
+
+		// A response the handler left unfinished cannot be followed by
+		// another one on the same connection.
+		if p.w.State != response.WriteStateDone || p.w.ShouldClose() {
+			return
+		}
+	}
+}
+
+func (s *Server) readRequests(conn *serverConn, queue chan<- *pendingResponse, quit <-chan struct{}) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("panic reading request from %s: %v\n%s", conn.RemoteAddr(), v, debug.Stack())
+		}
+	}()
+	defer close(queue)
+
+	var start time.Time
+	reader := request.NewReader(conn)
+	reader.Limits = s.limits()
+	reader.ObsFold = s.ObsFold
+	reader.AllowBareLF = s.AllowBareLF
+	reader.OnHeaders = func(*request.Request) {
+		conn.SetReadDeadline(deadline(start, s.ReadTimeout))
+	}
+
+	for {
+		if s.Closed.Load() {
+			return
+		}
+
+		if reader.Buffered() == 0 {
+			// Until the responses still being sent are done, the wait for
+			// the next request has no deadline.
+			conn.SetReadDeadline(time.Time{})
+			s.setWaiting(conn, true)
+			err := reader.WaitForRequest()
+			s.setWaiting(conn, false)
+			if err != nil {
+				return
+			}
+		}
+
+		start = time.Now()
+		headerTimeout := s.ReadHeaderTimeout
+		if headerTimeout == 0 {
+			headerTimeout = s.ReadTimeout
+		}
+		conn.SetReadDeadline(deadline(start, headerTimeout))
+
+		p := newPendingResponse(conn)
+		p.w.SetServer(s.ServerHeader)
+		s.addPending(conn, 1)
+
+		req, err := reader.ReadRequest()
+		if err != nil {
+			p.w.CloseConnection()
+			WriteHandlerError(p.w, readError(err))
+			p.err = p.w.Flush()
+			close(p.done)
+			select {
+			case queue <- p:
+			case <-quit:
+			}
+			return
+		}
+
+		// A response must announce if the connection ends with it, as it
+		// does once the server shuts down, when a client expecting 100
+		// Continue was never asked for the body, or when too much of the
+		// body is left to discard.
+		w := p.w
+		w.OnHeaders = func() {
+			if s.Closed.Load() || !req.CanDiscardBody() {
+				w.CloseConnection()
+			}
+		}
+
+		var body *handlerBody
+		if req.Body != request.NoBody {
+			body = &handlerBody{ReadCloser: req.Body, done: make(chan struct{})}
+			req.Body = body
+			if req.ExpectsContinue() {
+				body.sendContinue = func() error {
+					if w.State != response.WriteStateStatusLine {
+						return nil
+					}
+					w.WriteInformational(response.StatusContinue, nil)
+					return w.Flush()
+				}
+			}
+		}
+
+		p.w.SetVersion(req.RequestLine.HttpVersion)
+		keepAlive := req.KeepAlive()
+		if !keepAlive || s.Closed.Load() {
+			p.w.CloseConnection()
+		}
+
+		select {
+		case queue <- p:
+		case <-quit:
+			return
+		}
+		go s.handle(p, req)
+
+		if !keepAlive {
+			return
+		}
+
+		// The next request starts where the body of this one ends, so it
+		// cannot be read before the handler is done with the body.
+		if body != nil {
+			select {
+			case <-body.done:
+			case <-quit:
+				return
+			}
+		}
+	}
+}
+
+// handlerBody is the request body handed to a handler. It tells the
+// connection's reader when the handler is done with it, by reading it to the
+// end or closing it, and from then on leaves the underlying body alone.
+type handlerBody struct {
+	io.ReadCloser
+	err  error
+	once sync.Once
+	done chan struct{}
+	// sendContinue, if set, asks a client expecting 100 Continue for the
+	// body on the first Read. A handler that answers without reading the
+	// body, with a 417 or 413 say, never sends it.
+	sendContinue func() error
+}
+
+func (b *handlerBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if send := b.sendContinue; send != nil {
+		b.sendContinue = nil
+		if err := send(); err != nil {
+			b.err = err
+			b.release()
+			return 0, err
+		}
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.err = err
+		b.release()
+	}
+	return n, err
+}
+
+// Close discards what is left of the body, and reports an error if the
+// connection cannot be used for another request as a result.
+func (b *handlerBody) Close() error {
+	if b.err != nil {
+		return nil
+	}
+	b.err = request.ERROR_BODY_CLOSED
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+func (b *handlerBody) release() {
+	b.once.Do(func() { close(b.done) })
+}
+
+// deadline returns the time d after start, or the zero time, meaning no
+// deadline, if d is not positive.
+func deadline(start time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return start.Add(d)
+}
+
+// errAborted marks a response that was cut short by a panic or an error
+// returned after it had started, leaving the connection unusable.
+var errAborted = errors.New("response aborted")
+
+// ErrAbortHandler is a panic value that aborts the response like any other
+// panic, but that the server does not log. Middleware that has already
+// reported a panic can panic again with it once the response has started.
+var ErrAbortHandler = errors.New("server: abort handler")
+
+func (s *Server) handle(p *pendingResponse, req *request.Request) {
+	defer close(p.done)
+	defer func() {
+		if err := req.Body.Close(); err != nil {
+			p.w.CloseConnection()
+		}
+	}()
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v != ErrAbortHandler {
+			log.Printf("panic serving %s %s: %v\n%s",
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				v,
+				debug.Stack(),
+			)
+		}
+
+		if p.w.State != response.WriteStateStatusLine {
+			p.err = errAborted
+			return
+		}
+		p.w.CloseConnection()
+		WriteHandlerError(p.w, &HandlerError{
+			Status:  response.StatusInternalServerError,
+			Message: "Internal Server Error",
+		})
+		p.err = p.w.Flush()
+	}()
+
+	if herr := s.Handler(p.w, req); herr != nil {
+		// Once the response has started, the error cannot be sent without
+		// passing it off as part of the body.
+		if p.w.State != response.WriteStateStatusLine {
+			p.err = errAborted
+			return
+		}
+		WriteHandlerError(p.w, herr)
+	}
+	p.w.Finish()
+	p.err = p.w.Flush()
+}
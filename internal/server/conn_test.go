@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mugiwara999/httpfromtcp/internal/headers"
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnreadBody_AnnouncesClose(t *testing.T) {
+	reject := func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{Status: response.StatusContentTooLarge, Message: "Content Too Large"}
+	}
+	writeOwn := func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.StatusExpectationFailed)
+		w.WriteHeaders(response.GetDefaultHeader(0))
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		handler Handler
+		request string
+	}{
+		{
+			name:    "expect continue",
+			handler: reject,
+			request: "PUT /upload HTTP/1.1\r\nHost: test\r\nExpect: 100-continue\r\nContent-Length: 10\r\n\r\n",
+		},
+		{
+			name:    "expect continue, handler writes headers",
+			handler: writeOwn,
+			request: "PUT /upload HTTP/1.1\r\nHost: test\r\nExpect: 100-continue\r\nContent-Length: 10\r\n\r\n",
+		},
+		{
+			name:    "body too long to discard",
+			handler: reject,
+			request: "PUT /upload HTTP/1.1\r\nHost: test\r\nContent-Length: 1048576\r\n\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		conn := dial(t, startServer(t, &Server{Handler: tt.handler}))
+		io.WriteString(conn, tt.request)
+
+		out := readAll(t, conn)
+		assert.NotContains(t, out, "100 Continue", tt.name)
+		assert.Contains(t, out, "\r\nconnection: close\r\n", tt.name)
+		assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "), tt.name)
+	}
+}
+
+func TestUnreadBody_KeepsConnection(t *testing.T) {
+	addr := startServer(t, &Server{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{Status: response.StatusContentTooLarge, Message: "Content Too Large"}
+	}})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "PUT /a HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello"+
+		"PUT /b HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\nConnection: close\r\n\r\nworld")
+
+	out := readAll(t, conn)
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 413 Content Too Large\r\n"))
+	assert.Equal(t, 1, strings.Count(out, "connection: close"))
+}
+
+func writeText(w *response.Writer, body string) {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeader(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func TestPipelining_ResponseOrder(t *testing.T) {
+	fastDone := make(chan struct{})
+	addr := startServer(t, &Server{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		switch req.Target.Path {
+		case "/slow":
+			// Finish only after the request behind this one has.
+			select {
+			case <-fastDone:
+				writeText(w, "slow")
+			case <-time.After(2 * time.Second):
+				writeText(w, "slow, fast never ran")
+			}
+		case "/echo":
+			body, _ := io.ReadAll(req.Body)
+			writeText(w, string(body))
+		default:
+			writeText(w, "fast")
+			close(fastDone)
+		}
+		return nil
+	}})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: test\r\n\r\n"+
+		"POST /echo HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello"+
+		"GET /fast HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+
+	out := readAll(t, conn)
+	responses := strings.Split(out, "HTTP/1.1 200 OK\r\n")[1:]
+	if assert.Len(t, responses, 3) {
+		assert.True(t, strings.HasSuffix(responses[0], "\r\n\r\nslow"), responses[0])
+		assert.True(t, strings.HasSuffix(responses[1], "\r\n\r\nhello"), responses[1])
+		assert.True(t, strings.HasSuffix(responses[2], "\r\n\r\nfast"), responses[2])
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	addr := startServer(t, &Server{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		switch req.Target.Path {
+		case "/before":
+			panic("before the response")
+		case "/after":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeader(10))
+			w.Write([]byte("part"))
+			w.Flush()
+			panic("after the response started")
+		case "/abort":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeader(10))
+			w.Write([]byte("part"))
+			w.Flush()
+			panic(ErrAbortHandler)
+		}
+		writeText(w, "ok")
+		return nil
+	}})
+
+	// Test: A 500 that closes the connection, before anything was sent
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET /before HTTP/1.1\r\nHost: test\r\n\r\n"+
+		"GET /next HTTP/1.1\r\nHost: test\r\n\r\n")
+	out := readAll(t, conn)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"), out)
+	assert.Contains(t, out, "\r\nconnection: close\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+
+	// Test: The connection is cut once the response has started
+	conn = dial(t, addr)
+	io.WriteString(conn, "GET /after HTTP/1.1\r\nHost: test\r\n\r\n"+
+		"GET /next HTTP/1.1\r\nHost: test\r\n\r\n")
+	out = readAll(t, conn)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\npart"), out)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+
+	// Test: ErrAbortHandler cuts the connection the same way, without a log
+	conn = dial(t, addr)
+	io.WriteString(conn, "GET /abort HTTP/1.1\r\nHost: test\r\n\r\n"+
+		"GET /next HTTP/1.1\r\nHost: test\r\n\r\n")
+	out = readAll(t, conn)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\npart"), out)
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 "))
+	assert.Contains(t, logs.String(), "after the response started")
+	assert.NotContains(t, logs.String(), "GET /abort")
+
+	// Test: Other connections are served as usual
+	conn = dial(t, addr)
+	io.WriteString(conn, "GET /next HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasSuffix(readAll(t, conn), "\r\n\r\nok"))
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	addr := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			writeText(w, "ok")
+			return nil
+		},
+		ReadHeaderTimeout: 100 * time.Millisecond,
+	})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: te")
+
+	out := readAll(t, conn)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 408 Request Timeout\r\n"), out)
+	assert.Contains(t, out, "\r\nconnection: close\r\n")
+}
+
+func TestIdleTimeout(t *testing.T) {
+	addr := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			if req.Target.Path == "/slow" {
+				time.Sleep(300 * time.Millisecond)
+			}
+			writeText(w, "ok")
+			return nil
+		},
+		IdleTimeout: 100 * time.Millisecond,
+	})
+
+	// Test: A connection that never sends a request
+	conn := dial(t, addr)
+	assert.Empty(t, readAll(t, conn))
+
+	// Test: A keep-alive connection after its first response
+	conn = dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	assert.False(t, resp.Close)
+
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: The idle time starts once a slow handler's response is sent
+	conn = dial(t, addr)
+	reader = bufio.NewReader(conn)
+	for _, path := range []string{"/slow", "/"} {
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\n\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err, path)
+		io.Copy(io.Discard, resp.Body)
+		assert.False(t, resp.Close, path)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	readErr := make(chan error, 1)
+	addr := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			_, err := io.ReadAll(req.Body)
+			readErr <- err
+			return nil
+		},
+		ReadTimeout: 100 * time.Millisecond,
+	})
+
+	conn := dial(t, addr)
+	io.WriteString(conn, "PUT / HTTP/1.1\r\nHost: test\r\nContent-Length: 10\r\n\r\nhello")
+
+	assert.ErrorIs(t, <-readErr, os.ErrDeadlineExceeded)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	const size = 64 << 20
+	addr := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeader(size))
+			chunk := bytes.Repeat([]byte("x"), 1<<20)
+			for i := 0; i < size/len(chunk); i++ {
+				if _, err := w.Write(chunk); err != nil {
+					break
+				}
+			}
+			return nil
+		},
+		WriteTimeout: 100 * time.Millisecond,
+	})
+
+	// The client reads only after the deadline, so the server gives up on
+	// the response while it is still backed up.
+	conn := dial(t, addr)
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	time.Sleep(300 * time.Millisecond)
+
+	out, _ := io.ReadAll(conn)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.Less(t, len(out), size)
+}
+
+func TestHandlerError_AfterResponseStarted(t *testing.T) {
+	addr := startServer(t, &Server{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		switch req.Target.Path {
+		case "/headers":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(headers.NewHeaders())
+		case "/partial":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(headers.NewHeaders())
+			w.Write([]byte("partial data"))
+		default:
+			writeText(w, "ok")
+			return nil
+		}
+		return &HandlerError{Status: response.StatusInternalServerError, Message: "Internal Server Error"}
+	}})
+
+	for _, path := range []string{"/headers", "/partial"} {
+		conn := dial(t, addr)
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\n\r\n"+
+			"GET /next HTTP/1.1\r\nHost: test\r\n\r\n")
+
+		// The connection is cut rather than ending the response as if it
+		// had succeeded.
+		out := readAll(t, conn)
+		assert.NotContains(t, out, "Internal Server Error", path)
+		assert.NotContains(t, out, "0\r\n\r\n", path)
+		assert.NotContains(t, out, "ok", path)
+	}
+}
@@ -1,6 +1,11 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mugiwara999/httpfromtcp/internal/request"
 	"github.com/mugiwara999/httpfromtcp/internal/response"
 )
 
@@ -11,7 +16,37 @@ func WriteHandlerError(w *response.Writer, herr *HandlerError) {
 
 	message := herr.Message + "\n"
 
+	h := response.GetDefaultHeader(len(message))
+	herr.Headers.ForEach(func(name string, values []string) {
+		for i, v := range values {
+			if i == 0 {
+				h.Replace(name, v)
+			} else {
+				h.Set(name, v)
+			}
+		}
+	})
+
 	w.WriteStatusLine(herr.Status)
-	w.WriteHeaders(response.GetDefaultHeader(len(message)))
+	w.WriteHeaders(h)
 	w.WriteBody([]byte(message))
 }
+
+// readError returns the error response for a request that could not be
+// read, using the status and reason carried by a request.ParseError.
+func readError(err error) *HandlerError {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return &HandlerError{Status: response.StatusRequestTimeout, Message: "Request Timeout"}
+	}
+
+	var perr *request.ParseError
+	if errors.As(err, &perr) {
+		status := response.StatusCode(perr.Status)
+		return &HandlerError{
+			Status:  status,
+			Message: fmt.Sprintf("%s: %s", response.StatusText(status), perr.Reason),
+		}
+	}
+
+	return &HandlerError{Status: response.StatusBadRequest, Message: "Bad Request"}
+}
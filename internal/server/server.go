@@ -1,11 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/mugiwara999/httpfromtcp/internal/headers"
 	"github.com/mugiwara999/httpfromtcp/internal/request"
 	"github.com/mugiwara999/httpfromtcp/internal/response"
 )
@@ -14,6 +17,48 @@ type Server struct {
 	Listener net.Listener
 	Closed   atomic.Bool
 	Handler  Handler
+
+	// ReadHeaderTimeout bounds the time from the first byte of a request to
+	// the end of its headers. A client that is too slow gets a 408. If zero,
+	// ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time from the first byte of a request to the
+	// end of its body. Zero means no limit.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time spent sending each response once it is
+	// its turn to be written. Zero means no limit.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds how long a keep-alive connection may wait for its
+	// next request before it is closed. If zero, ReadTimeout is used.
+	IdleTimeout time.Duration
+
+	// The limits below default to the values in request.DefaultLimits when
+	// zero, and are turned off when negative.
+	//
+	// MaxRequestLineBytes bounds the request line; longer ones get a 414.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes bounds the header section and MaxHeaderCount the number
+	// of field lines in it; requests over either get a 431.
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	// MaxBodyBytes bounds the request body; larger ones get a 413. A handler
+	// that streams large uploads can set it negative and bound the body
+	// itself.
+	MaxBodyBytes int64
+
+	// ObsFold decides whether header fields folded over several lines are
+	// rejected with a 400, the default, or unfolded.
+	ObsFold headers.ObsFoldPolicy
+	// AllowBareLF accepts requests whose lines end in a lone LF instead of
+	// CRLF, for clients such as hand-written scripts. Off by default.
+	AllowBareLF bool
+
+	// ServerHeader, if set, is sent as the Server header of every response
+	// that does not set its own.
+	ServerHeader string
+
+	mu    sync.Mutex
+	conns map[*serverConn]struct{}
 }
 
 type Handler func(w *response.Writer, req *request.Request) *HandlerError
@@ -21,59 +66,139 @@ type Handler func(w *response.Writer, req *request.Request) *HandlerError
 type HandlerError struct {
 	Status  response.StatusCode
 	Message string
+	Headers *headers.Headers
 }
 
-func (s *Server) runConnection(conn net.Conn) {
-	defer conn.Close()
-
-	req, err := request.RequestFromReader(conn)
-	w := response.NewWriter()
-	if err != nil {
-		WriteHandlerError(w, &HandlerError{
-			Status:  response.StatusBadRequest,
-			Message: "Bad Request",
-		})
-		goto copy
+// limits returns the configured request limits, using the value from
+// request.DefaultLimits for any that is zero and no limit for any that is
+// negative.
+func (s *Server) limits() request.Limits {
+	limits := request.DefaultLimits
+	switch {
+	case s.MaxRequestLineBytes > 0:
+		limits.MaxRequestLineBytes = s.MaxRequestLineBytes
+	case s.MaxRequestLineBytes < 0:
+		limits.MaxRequestLineBytes = 0
 	}
-	if req == nil {
-		WriteHandlerError(w, &HandlerError{
-			Status:  response.StatusBadRequest,
-			Message: "Bad Request",
-		})
-		goto copy
+	switch {
+	case s.MaxHeaderBytes > 0:
+		limits.MaxHeaderBytes = s.MaxHeaderBytes
+	case s.MaxHeaderBytes < 0:
+		limits.MaxHeaderBytes = 0
 	}
-
-	if herr := s.Handler(w, req); herr != nil {
-		WriteHandlerError(w, herr)
-		goto copy
+	switch {
+	case s.MaxHeaderCount > 0:
+		limits.MaxHeaderCount = s.MaxHeaderCount
+	case s.MaxHeaderCount < 0:
+		limits.MaxHeaderCount = 0
 	}
-
-copy:
-	_, err = io.Copy(conn, w)
-	if err != nil {
-		return
+	switch {
+	case s.MaxBodyBytes > 0:
+		limits.MaxBodyBytes = s.MaxBodyBytes
+	case s.MaxBodyBytes < 0:
+		limits.MaxBodyBytes = 0
 	}
+	return limits
 }
 
 func Serve(port uint16, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
-	if err != nil {
+	server := &Server{
+		Handler: handler,
+	}
+
+	if err := server.Start(port); err != nil {
 		return nil, err
 	}
+	return server, nil
+}
 
-	server := &Server{
-		Listener: listener,
-		Handler:  handler,
+// Start listens on port and serves connections in the background. It lets a
+// Server be configured before any connection is accepted:
+//
+//	s := &server.Server{Handler: h, ReadTimeout: 30 * time.Second}
+//	err := s.Start(42069)
+func (s *Server) Start(port uint16) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	if err != nil {
+		return err
 	}
 
-	go server.Listen()
-	return server, nil
+	s.Listener = listener
+	go s.Listen()
+	return nil
 }
 
+// Close stops accepting connections and closes every open one at once,
+// cutting off any response in progress. Use Shutdown to let them finish.
 func (s *Server) Close() error {
 	s.Closed.Store(true)
 
-	return s.Listener.Close()
+	err := s.Listener.Close()
+	s.closeConns(false)
+	return err
+}
+
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown stops accepting connections, closes connections that are idle
+// between requests, and waits for the others to finish the requests they
+// have already received. Responses sent meanwhile carry "connection: close"
+// so each connection ends after its current work. If ctx is done first, the
+// remaining connections are closed and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Closed.Store(true)
+	err := s.Listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.closeConns(true) == 0 {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.closeConns(false)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeConns closes the tracked connections, or only the idle ones when
+// idleOnly is set, and returns how many are left open.
+func (s *Server) closeConns(idleOnly bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	left := 0
+	for c := range s.conns {
+		if !idleOnly || c.idle() {
+			c.Close()
+			delete(s.conns, c)
+			continue
+		}
+		left++
+	}
+	return left
+}
+
+func (s *Server) trackConn(c *serverConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = map[*serverConn]struct{}{}
+	}
+	s.conns[c] = struct{}{}
+}
+
+func (s *Server) untrackConn(c *serverConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, c)
 }
 
 func (s *Server) Listen() {
@@ -87,7 +212,15 @@ func (s *Server) Listen() {
 			continue
 		}
 
-		go s.runConnection(conn)
+		c := &serverConn{Conn: conn}
+		s.trackConn(c)
+		if s.Closed.Load() {
+			s.untrackConn(c)
+			conn.Close()
+			continue
+		}
+
+		go s.runConnection(c)
 
 	}
 }
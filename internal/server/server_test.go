@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves s on a free local port until the test ends, and
+// returns its address.
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s.Listener = listener
+	go s.Listen()
+	t.Cleanup(func() { s.Close() })
+	return listener.Addr().String()
+}
+
+// dial connects to addr. Reads and writes fail after a few seconds, so a
+// server that never answers fails the test instead of hanging it.
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readAll reads from conn until the server closes it.
+func readAll(t *testing.T, conn net.Conn) string {
+	t.Helper()
+
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestShutdown_DrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	s := &Server{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		writeText(w, "done")
+		return nil
+	}}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	out := readAll(t, conn)
+	assert.Contains(t, out, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, out, "\r\nconnection: close\r\n")
+	assert.Contains(t, out, "\r\n\r\ndone")
+	assert.NoError(t, <-shutdown)
+}
+
+func TestShutdown_ClosesIdleConnections(t *testing.T) {
+	s := &Server{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		writeText(w, "ok")
+		return nil
+	}}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	assert.False(t, resp.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+
+	rest, _ := io.ReadAll(reader)
+	assert.Empty(t, rest)
+}
+
+func TestShutdown_ForceCloseAfterDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	s := &Server{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		<-release
+		return nil
+	}}
+	conn := dial(t, startServer(t, s))
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	out, _ := io.ReadAll(conn)
+	assert.Empty(t, out)
+}
+
+func TestLimits(t *testing.T) {
+	assert.Equal(t, request.DefaultLimits, (&Server{}).limits())
+
+	assert.Equal(t, request.Limits{
+		MaxRequestLineBytes: 100,
+		MaxHeaderBytes:      request.DefaultLimits.MaxHeaderBytes,
+		MaxHeaderCount:      0,
+		MaxBodyBytes:        0,
+	}, (&Server{
+		MaxRequestLineBytes: 100,
+		MaxHeaderCount:      -1,
+		MaxBodyBytes:        -1,
+	}).limits())
+}
+
+func TestLimits_UnlimitedBody(t *testing.T) {
+	const size = 11 << 20
+	addr := startServer(t, &Server{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			n, err := io.Copy(io.Discard, req.Body)
+			if err != nil {
+				return &HandlerError{Status: response.StatusBadRequest, Message: err.Error()}
+			}
+			writeText(w, strconv.FormatInt(n, 10))
+			return nil
+		},
+		MaxBodyBytes: -1,
+	})
+
+	conn := dial(t, addr)
+	go func() {
+		io.WriteString(conn, "PUT / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n"+
+			"Content-Length: "+strconv.Itoa(size)+"\r\n\r\n")
+		conn.Write(make([]byte, size))
+	}()
+
+	out := readAll(t, conn)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+strconv.Itoa(size)), out)
+}
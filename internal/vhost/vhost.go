@@ -0,0 +1,90 @@
+package vhost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/mugiwara999/httpfromtcp/internal/server"
+)
+
+// Mux dispatches requests to handlers registered by host name, so that a
+// single server can serve several sites. A pattern is either an exact host
+// name, "example.test", or a wildcard, "*.example.test", that matches any
+// subdomain of example.test but not example.test itself.
+//
+// An exact pattern wins over wildcards, and among wildcards the longest
+// suffix wins. Ports are not part of the match.
+type Mux struct {
+	exact     map[string]server.Handler
+	wildcards map[string]server.Handler
+	fallback  server.Handler
+}
+
+func New() *Mux {
+	return &Mux{
+		exact:     map[string]server.Handler{},
+		wildcards: map[string]server.Handler{},
+	}
+}
+
+// Handle registers handler for requests addressed to hosts matching
+// pattern. It panics if the pattern is malformed or already registered.
+func (m *Mux) Handle(pattern string, handler server.Handler) {
+	name, wildcard := strings.CutPrefix(strings.ToLower(pattern), "*.")
+	if name == "" || strings.ContainsAny(name, "*:/") {
+		panic(fmt.Sprintf("vhost: invalid host pattern %q", pattern))
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	patterns := m.exact
+	if wildcard {
+		patterns = m.wildcards
+	}
+	if _, ok := patterns[name]; ok {
+		panic(fmt.Sprintf("vhost: host pattern %q registered twice", pattern))
+	}
+	patterns[name] = handler
+}
+
+// Default sets the handler for requests matching no pattern, including
+// HTTP/1.0 requests that name no host.
+func (m *Mux) Default(handler server.Handler) {
+	m.fallback = handler
+}
+
+// Serve is a server.Handler that runs the handler registered for the host
+// of the request. Without a match or a default handler it answers 421
+// Misdirected Request.
+func (m *Mux) Serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	if handler := m.match(req.Host.Name); handler != nil {
+		return handler(w, req)
+	}
+	return &server.HandlerError{
+		Status:  response.StatusMisdirectedRequest,
+		Message: "Misdirected Request",
+	}
+}
+
+func (m *Mux) match(host string) server.Handler {
+	if host == "" {
+		return m.fallback
+	}
+	if handler, ok := m.exact[host]; ok {
+		return handler
+	}
+
+	// Try the parent domains of host from the longest down.
+	for suffix := host; ; {
+		_, parent, ok := strings.Cut(suffix, ".")
+		if !ok {
+			break
+		}
+		if handler, ok := m.wildcards[parent]; ok {
+			return handler
+		}
+		suffix = parent
+	}
+	return m.fallback
+}
@@ -0,0 +1,97 @@
+package vhost
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mugiwara999/httpfromtcp/internal/request"
+	"github.com/mugiwara999/httpfromtcp/internal/response"
+	"github.com/mugiwara999/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMux_Match(t *testing.T) {
+	var got string
+	m := New()
+	for _, pattern := range []string{"example.test", "*.example.test", "*.api.example.test", "Blog.Example.Test"} {
+		m.Handle(pattern, func(w *response.Writer, req *request.Request) *server.HandlerError {
+			got = pattern
+			return nil
+		})
+	}
+
+	tests := []struct {
+		request string
+		pattern string
+	}{
+		{"GET / HTTP/1.1\r\nHost: example.test\r\n\r\n", "example.test"},
+		{"GET / HTTP/1.1\r\nHost: EXAMPLE.test:8080\r\n\r\n", "example.test"},
+		{"GET / HTTP/1.1\r\nHost: example.test.\r\n\r\n", "example.test"},
+		{"GET / HTTP/1.1\r\nHost: www.example.test\r\n\r\n", "*.example.test"},
+		{"GET / HTTP/1.1\r\nHost: a.b.example.test\r\n\r\n", "*.example.test"},
+		{"GET / HTTP/1.1\r\nHost: blog.example.test\r\n\r\n", "Blog.Example.Test"},
+		{"GET / HTTP/1.1\r\nHost: v1.api.example.test\r\n\r\n", "*.api.example.test"},
+		{"GET / HTTP/1.1\r\nHost: api.example.test\r\n\r\n", "*.example.test"},
+		// The authority of an absolute-form target overrides the Host field.
+		{"GET http://www.example.test/ HTTP/1.1\r\nHost: other.test\r\n\r\n", "*.example.test"},
+	}
+
+	for _, tt := range tests {
+		req, err := request.RequestFromReader(strings.NewReader(tt.request))
+		require.NoError(t, err, tt.request)
+
+		got = ""
+		herr := m.Serve(response.NewWriter(&bytes.Buffer{}), req)
+		require.Nil(t, herr, tt.request)
+		assert.Equal(t, tt.pattern, got, tt.request)
+	}
+}
+
+func TestMux_Misdirected(t *testing.T) {
+	var got string
+	m := New()
+	m.Handle("*.example.test", func(w *response.Writer, req *request.Request) *server.HandlerError {
+		got = "sub"
+		return nil
+	})
+
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\nHost: example.test\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: other.test\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: notexample.test\r\n\r\n",
+		"GET / HTTP/1.0\r\n\r\n",
+	} {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err, raw)
+
+		herr := m.Serve(response.NewWriter(&bytes.Buffer{}), req)
+		require.NotNil(t, herr, raw)
+		assert.Equal(t, response.StatusMisdirectedRequest, herr.Status, raw)
+	}
+	assert.Equal(t, "", got)
+
+	m.Default(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		got = "default"
+		return nil
+	})
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: other.test\r\n\r\n"))
+	require.NoError(t, err)
+	herr := m.Serve(response.NewWriter(&bytes.Buffer{}), req)
+	require.Nil(t, herr)
+	assert.Equal(t, "default", got)
+}
+
+func TestMux_InvalidPatterns(t *testing.T) {
+	m := New()
+	h := func(w *response.Writer, req *request.Request) *server.HandlerError { return nil }
+
+	assert.Panics(t, func() { m.Handle("", h) })
+	assert.Panics(t, func() { m.Handle("*.", h) })
+	assert.Panics(t, func() { m.Handle("a.*.example.test", h) })
+	assert.Panics(t, func() { m.Handle("example.test:8080", h) })
+
+	m.Handle("*.example.test", h)
+	assert.Panics(t, func() { m.Handle("*.EXAMPLE.test", h) })
+}